@@ -0,0 +1,244 @@
+// Package auth provides login and logout pages, password verification,
+// two-factor authentication, remember me logins, protection of paths which
+// require logged in user and role or permission based authorization of routes.
+//
+// Install has to be called after settings are initialized, e.g. with
+// upendo.AddSetupFunc.
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/csrf"
+	"github.com/solgar/upendo/pages"
+	"github.com/solgar/upendo/ratelimit"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+var (
+	protectedPrefixes []string
+	// counts failed logins, set by Install if -login-max-failures is set
+	loginLockout *ratelimit.Lockout
+
+	loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html><html><head><title>Log in</title></head><body>
+{{if .loginError}}<p>{{.loginError}}</p>{{end}}
+<form method="post" action="{{.loginURL}}">
+<input type="hidden" name="return" value="{{.returnURL}}">
+{{.csrfField}}
+<p><label>Login <input type="text" name="login" autofocus></label></p>
+<p><label>Password <input type="password" name="password"></label></p>
+{{if .rememberMe}}<p><label><input type="checkbox" name="remember" value="1"> Remember me</label></p>{{end}}
+<p><input type="submit" value="Log in"></p>
+</form>
+</body></html>
+`))
+)
+
+// Controller handles login and logout requests.
+type Controller router.Controller
+
+// Install registers login, logout and second factor routes and pre route
+// functions for API key and bearer token authentication, remember me logins,
+// pending second factors, protected paths and route authorization. Credential store is created from settings unless it was
+// set with SetCredentialStore, bearer token keys unless set with SetJWTKeys.
+func Install() {
+	if credentialStore == nil {
+		store, err := NewCredentialStoreFromSettings()
+		if err != nil {
+			panic(err)
+		}
+		credentialStore = store
+	}
+	if jwtKeys == nil {
+		keys, err := parseJWTKeys(settings.JWTKeys)
+		if err != nil {
+			panic(err)
+		}
+		jwtKeys = keys
+	}
+
+	router.Add("GET", settings.LoginURL, Controller{}, "LoginPage")
+	router.Add("POST", settings.LoginURL, Controller{}, "Login")
+	router.Add("POST", settings.LogoutURL, Controller{}, "Logout")
+	router.Add("GET", settings.TwoFactorURL, Controller{}, "TwoFactorPage")
+	router.Add("POST", settings.TwoFactorURL, Controller{}, "TwoFactor")
+
+	if settings.LoginRateLimit > 0 {
+		rate := ratelimit.Rate{Limit: settings.LoginRateLimit, Per: time.Minute}
+		ratelimit.Limit("POST", settings.LoginURL, ratelimit.New("login", rate, ratelimit.ByIP))
+	}
+	if settings.LoginMaxFailures > 0 {
+		loginLockout = ratelimit.NewLockout("login-failures", settings.LoginMaxFailures, settings.LoginLockout, settings.LoginLockoutMax)
+	}
+
+	router.AddPreRouteFunc(AuthenticateToken)
+	router.AddPreRouteFunc(LoginRemembered)
+	router.AddPreRouteFunc(checkTwoFactor)
+	router.AddPreRouteFunc(checkLoginRequired)
+	router.AddPreRouteFunc(checkAuthorization)
+}
+
+// RequireLogin makes paths starting with any of given prefixes available only
+// to logged in users. Anonymous users are redirected to login page, which sends
+// them back after logging in.
+func RequireLogin(prefixes ...string) {
+	protectedPrefixes = append(protectedPrefixes, prefixes...)
+}
+
+func isProtected(path string) bool {
+	if path == settings.LoginURL {
+		return false
+	}
+	for _, prefix := range protectedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkLoginRequired(cv reflect.Value) {
+	path := controller.CGet(cv, "path").(string)
+	if !isProtected(path) || router.ErrorPage(controller.CMap(cv)) {
+		return
+	}
+	controller.CheckSession(cv)
+	if s, _ := controller.CGet(cv, "session").(*session.Session); !s.Anonymous() {
+		return
+	}
+
+	r := controller.CGet(cv, "request").(*http.Request)
+	c := controller.CMap(cv)
+	router.Redirect(c, settings.LoginURL+"?return="+url.QueryEscape(r.URL.RequestURI()))
+	router.StopRouting(c)
+}
+
+// SafeReturnURL returns given URL if it points to this site, otherwise login
+// redirect is returned. It prevents using login page as open redirect.
+func SafeReturnURL(u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return settings.LoginRedirect
+	}
+	return u
+}
+
+// LoginPage renders login template if there is one, built-in form otherwise.
+// Template gets "loginError", "returnURL", "loginURL", "rememberMe" and
+// "csrfField" values.
+func (c Controller) LoginPage() {
+	r := c["request"].(*http.Request)
+	if _, ok := c["returnURL"]; !ok {
+		c["returnURL"] = SafeReturnURL(r.URL.Query().Get("return"))
+	}
+	c["loginURL"] = settings.LoginURL
+	c["rememberMe"] = settings.RememberMeDuration > 0
+	c["csrfField"] = csrf.Field(c)
+	c.render(settings.LoginTemplate, loginForm)
+}
+
+// render renders template with given name if there is one, built-in template
+// otherwise.
+func (c Controller) render(name string, builtIn *template.Template) {
+	if pages.HasPage(name) {
+		controller.HandlePageTemplate(c, name)
+		return
+	}
+	c["Content-Type"] = "text/html; charset=utf-8"
+	err := builtIn.Execute(c["writer"].(*bytes.Buffer), map[string]interface{}(c))
+	controller.PanicIfNeeded(err)
+}
+
+// loginFailed renders login page again with given error and status code.
+func (c Controller) loginFailed(returnURL, message string, statusCode int) {
+	c["loginError"] = message
+	c["returnURL"] = returnURL
+	c["StatusCode"] = statusCode
+	c.LoginPage()
+}
+
+// Login checks credentials sent in "login" and "password" form fields. On
+// success session is created and user is sent to "return" URL, or to second
+// factor page first if user enabled it. Otherwise login page is rendered again
+// with 401 status. Login is locked for a while after
+// too many failures, 429 status is sent then.
+func (c Controller) Login() {
+	r := c["request"].(*http.Request)
+	w := c["__writer"].(http.ResponseWriter)
+	controller.PanicIfNeeded(r.ParseForm())
+
+	login := r.PostForm.Get("login")
+	returnURL := SafeReturnURL(r.PostForm.Get("return"))
+
+	if loginLockout != nil {
+		left, err := loginLockout.Locked(login)
+		controller.PanicIfNeeded(err)
+		if left > 0 {
+			controller.AddHeader(c, "Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+			c.loginFailed(returnURL, "Too many failed logins, try again later.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	ok, err := Authenticate(login, r.PostForm.Get("password"))
+	controller.PanicIfNeeded(err)
+	if !ok {
+		fmt.Println("Failed login attempt for:", login)
+		if loginLockout != nil {
+			_, err := loginLockout.Fail(login)
+			controller.PanicIfNeeded(err)
+		}
+		c.loginFailed(returnURL, "Invalid login or password.", http.StatusUnauthorized)
+		return
+	}
+	if loginLockout != nil {
+		controller.PanicIfNeeded(loginLockout.Succeed(login))
+	}
+
+	smanager := session.GetManager()
+	if smanager == nil {
+		panic("Session manager not initialized.")
+	}
+	rememberMe := settings.RememberMeDuration > 0 && r.PostForm.Get("remember") != ""
+	twoFactor, err := TwoFactorEnabled(login)
+	controller.PanicIfNeeded(err)
+	if twoFactor {
+		StartTwoFactor(c, rememberMe)
+	}
+	c["login"] = login
+	_, err = smanager.CreateSession(c)
+	controller.PanicIfNeeded(err)
+	if twoFactor {
+		router.Redirect(c, settings.TwoFactorURL+"?return="+url.QueryEscape(returnURL))
+		return
+	}
+	if rememberMe {
+		if err := remember(w, login); err != nil {
+			fmt.Println("Cannot remember login:", err)
+		}
+	}
+	router.Redirect(c, returnURL)
+}
+
+// Logout expires current session and remember me token.
+func (c Controller) Logout() {
+	r := c["request"].(*http.Request)
+	w := c["__writer"].(http.ResponseWriter)
+	if smanager := session.GetManager(); smanager != nil {
+		smanager.ExpireSession(c)
+		delete(c, "session")
+	}
+	forget(w, r)
+	router.Redirect(c, settings.LogoutRedirect)
+}
@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+
+	sessionsOnce sync.Once
+	users        = session.NewMemoryUserProvider()
+)
+
+// startSessions starts session manager keeping sessions in cookies, users
+// are looked up in users.
+func startSessions() {
+	sessionsOnce.Do(func() {
+		settings.SessionMode, settings.SessionCookieKeys = session.SessionModeCookie, "test cookie key 0123456789"
+		settings.RestoreSessions, settings.SessionCookieName = false, "session"
+		settings.UserProvider = session.UserProviderMemory
+		session.Initialize()
+		session.GetManager().SetUserProvider(users)
+		users.AddUser(1, "alice")
+	})
+}
+
+type testController map[string]interface{}
+
+func (c testController) Data() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "data of ", c["session"].(*session.Session).UserName)
+}
+
+func (c testController) Error() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "error ", c["code"])
+}
+
+func TestAuthenticate(t *testing.T) {
+	_t = t
+	store := NewMemoryCredentialStore()
+	assert(store.SetPassword("alice", "secret") == nil, "Password should be set.")
+	SetCredentialStore(store)
+	defer SetCredentialStore(nil)
+
+	ok, err := Authenticate("alice", "secret")
+	assert(ok && err == nil, "Valid password should be accepted.")
+	ok, err = Authenticate("alice", "Secret")
+	assert(!ok && err == nil, "Invalid password should be rejected.")
+	ok, err = Authenticate("bob", "secret")
+	assert(!ok && err == nil, "Unknown login should be rejected without error.")
+}
+
+func TestSafeReturnURL(t *testing.T) {
+	_t = t
+	settings.LoginRedirect = "/home"
+	assert(SafeReturnURL("/account?tab=1") == "/account?tab=1", "Local path should be kept.")
+	assert(SafeReturnURL("") == "/home", "Empty URL should fall back to login redirect.")
+	assert(SafeReturnURL("http://evil.example") == "/home", "Absolute URL should be rejected.")
+	assert(SafeReturnURL("//evil.example") == "/home", "Protocol relative URL should be rejected.")
+	assert(SafeReturnURL("/\\evil.example") == "/home", "Backslash URL should be rejected.")
+}
+
+func TestRememberToken(t *testing.T) {
+	_t = t
+	settings.RememberMeDuration = time.Hour
+	SetRememberStore(NewMemoryRememberStore())
+
+	w := httptest.NewRecorder()
+	assert(remember(w, "alice") == nil, "Token should be created.")
+	cookie := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	login, ok := checkRememberedToken(r)
+	assert(ok && login == "alice", "Valid token should log user in.")
+	_, ok = checkRememberedToken(r)
+	assert(ok, "Used token should stay valid for a while.")
+	selector, _, _ := rememberCookie(r)
+	token, _ := rememberStore.Get(selector)
+	assert(token.Expires <= time.Now().Add(rotationGrace).Unix(), "Used token should expire soon.")
+	token.Expires = time.Now().Unix() - 1
+	rememberStore.Save(token)
+	_, ok = checkRememberedToken(r)
+	assert(!ok, "Used token should not be valid after grace period.")
+
+	w = httptest.NewRecorder()
+	remember(w, "alice")
+	cookie = w.Result().Cookies()[0]
+	cookie.Value = cookie.Value[:len(cookie.Value)-1] + "x"
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	_, ok = checkRememberedToken(r)
+	assert(!ok, "Token with wrong validator should be rejected.")
+	_, err := rememberStore.Get(cookie.Value[:2*selectorSize])
+	assert(err == ErrTokenNotFound, "Token with wrong validator should be removed.")
+}
+
+func (c testController) Feed() {
+	LoginRemembered(reflect.ValueOf(c))
+	_, ok := c["session"]
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "session: ", ok)
+}
+
+func TestLoginRemembered(t *testing.T) {
+	_t = t
+	startSessions()
+	settings.RememberMeDuration, settings.RoutingChainMax = time.Hour, 4
+	SetRememberStore(NewMemoryRememberStore())
+	router.Add("GET", "/feed", testController{}, "Feed")
+	controller.SkipSession("GET", "/feed")
+
+	w := httptest.NewRecorder()
+	remember(w, "alice")
+	cookie := w.Result().Cookies()[0]
+	r := httptest.NewRequest("GET", "/feed", nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	assert(w.Body.String() == "session: false" && len(w.Result().Cookies()) == 0, "Route without session should not log user in.")
+
+	w = httptest.NewRecorder()
+	c := testController{"request": r, "path": "/", "__writer": w}
+	LoginRemembered(reflect.ValueOf(c))
+	s, _ := c["session"].(*session.Session)
+	assert(s != nil && s.UserName == "alice", "Remembered user should be logged in.")
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	_t = t
+	store := NewMemoryCredentialStore()
+	store.UpdatePasswordHash("alice", security.EncryptPassword("secret", "salt"))
+	store.credentials["alice"].Salt = "salt"
+	SetCredentialStore(store)
+	defer SetCredentialStore(nil)
+
+	ok, err := Authenticate("alice", "secret")
+	assert(ok && err == nil, "Legacy hash should be accepted.")
+	c, _ := store.Credentials("alice")
+	assert(strings.HasPrefix(c.PasswordHash, "$argon2id$"), "Legacy hash should be replaced.")
+	ok, _ = Authenticate("alice", "secret")
+	assert(ok, "Password should be accepted after rehash.")
+}
+
+func TestAPIKey(t *testing.T) {
+	_t = t
+	SetAPIKeyStore(NewMemoryAPIKeyStore())
+	key, err := CreateAPIKey("alice")
+	assert(err == nil, "API key should be created.")
+	login, err := verifyAPIKey(key)
+	assert(err == nil && login == "alice", "Valid API key should be accepted.")
+
+	id := strings.SplitN(key, ".", 2)[0]
+	stored, _ := apiKeyStore.APIKey(id)
+	assert(!strings.Contains(key, stored.SecretHash), "Only hash of the secret should be stored.")
+	_, err = verifyAPIKey(id + ".wrong")
+	assert(err == ErrInvalidCredentials, "API key with wrong secret should be rejected.")
+
+	assert(RevokeAPIKey(id) == nil, "API key should be revoked.")
+	_, err = verifyAPIKey(key)
+	assert(err == ErrInvalidCredentials, "Revoked API key should be rejected.")
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	_t = t
+	startSessions()
+	settings.RoutingChainMax = 4
+	SetAPIKeyStore(NewMemoryAPIKeyStore())
+	key, _ := CreateAPIKey("alice")
+	router.Add("GET", "/api/data", testController{}, "Data")
+	router.Add("GET", "/error/:code", testController{}, "Error")
+	router.AddPreRouteFunc(AuthenticateToken)
+
+	get := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/data", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		router.RouteRequest(w, r)
+		return w
+	}
+	w := get("ApiKey bogus.key")
+	assert(w.Code == http.StatusUnauthorized && w.Body.String() == "error 401", "Invalid API key should be routed to error page.")
+	assert(strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer"), "Challenge should be sent.")
+	w = get("ApiKey " + key)
+	assert(w.Code == http.StatusOK && w.Body.String() == "data of alice", "Valid API key should authenticate request.")
+}
+
+func TestBearerToken(t *testing.T) {
+	_t = t
+	keys, err := parseJWTKeys("k2:second secret key, k1:first secret key")
+	assert(err == nil && len(keys) == 2 && keys[0].ID == "k2", "JWT keys should be parsed.")
+	_, err = parseJWTKeys("k1:short")
+	assert(err != nil, "Short JWT secret should be rejected.")
+
+	settings.JWTTokenTTL, settings.JWTIssuer, settings.JWTAudience = time.Minute, "upendo", "api"
+	defer func() { settings.JWTIssuer, settings.JWTAudience = "", "" }()
+	SetJWTKeys(keys[1])
+	token, err := IssueToken("alice")
+	assert(err == nil, "Token should be issued.")
+	SetJWTKeys(keys...)
+	defer SetJWTKeys()
+	login, err := verifyBearerToken(token)
+	assert(err == nil && login == "alice", "Token signed with previous key should be accepted.")
+
+	settings.JWTAudience = "admin"
+	_, err = verifyBearerToken(token)
+	assert(err != nil, "Token for other audience should be rejected.")
+}
+
+func TestTwoFactor(t *testing.T) {
+	_t = t
+	settings.TOTPWindow, settings.RecoveryCodes = 1, 2
+	SetTwoFactorStore(NewMemoryTwoFactorStore())
+
+	secret, uri := NewTOTPSecret("alice")
+	assert(strings.Contains(uri, secret), "URI should contain the secret.")
+	_, err := EnableTwoFactor("alice", secret, "000000x")
+	assert(err == ErrInvalidCode, "Second factor should not be enabled with invalid code.")
+	enabled, _ := TwoFactorEnabled("alice")
+	assert(!enabled, "Second factor should not be enabled yet.")
+
+	code, _ := security.TOTPCode(secret, time.Now())
+	recoveryCodes, err := EnableTwoFactor("alice", secret, code)
+	assert(err == nil && len(recoveryCodes) == 2, "Second factor should be enabled.")
+	ok, _ := verifySecondFactor("alice", code)
+	assert(!ok, "Code used for enrollment should not be accepted again.")
+
+	ok, _ = verifySecondFactor("alice", recoveryCodes[0])
+	assert(ok, "Recovery code should be accepted.")
+	ok, _ = verifySecondFactor("alice", recoveryCodes[0])
+	assert(!ok, "Recovery code should be usable only once.")
+
+	assert(DisableTwoFactor("alice") == nil, "Second factor should be disabled.")
+	_, err = verifySecondFactor("alice", recoveryCodes[1])
+	assert(err == ErrNoTwoFactor, "Disabled second factor should not be verified.")
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	_t = t
+	startSessions()
+	store := NewMemoryCredentialStore()
+	store.SetPassword("alice", "secret")
+	SetCredentialStore(store)
+	defer SetCredentialStore(nil)
+	SetTwoFactorStore(NewMemoryTwoFactorStore())
+	secret, _ := NewTOTPSecret("alice")
+	code, _ := security.TOTPCode(secret, time.Now())
+	EnableTwoFactor("alice", secret, code)
+	settings.TwoFactorURL, settings.LoginRedirect = "/login/2fa", "/"
+
+	form := url.Values{"login": {"alice"}, "password": {"secret"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	c := Controller{"request": r, "__writer": w}
+	c.Login()
+	assert(strings.HasPrefix(c["Location"].(string), "/login/2fa"), "User should be sent to second factor page.")
+
+	cookies := w.Result().Cookies()
+	assert(len(cookies) > 0 && cookies[0].Name == "session", "Session cookie should be sent.")
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	s := session.GetManager().GetSession(r)
+	assert(s != nil && s.UserName == "alice" && s.TwoFactorPending(), "First session cookie should wait for second factor.")
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/session"
+)
+
+// names of route options holding authorization requirements
+const (
+	rolesOption       = "auth.roles"
+	permissionsOption = "auth.permissions"
+)
+
+// RequireRole allows route added with router.Add only to users having any of
+// given roles (directly or through inheritance, see session.DefineRole).
+func RequireRole(method, path string, roles ...string) {
+	router.SetRouteOption(method, path, rolesOption, roles)
+}
+
+// RequirePermission allows route added with router.Add only to users having
+// all given permissions.
+func RequirePermission(method, path string, permissions ...string) {
+	router.SetRouteOption(method, path, permissionsOption, permissions)
+}
+
+// Authorized reports if session meets authorization requirements of route
+// which is being handled.
+func Authorized(c map[string]interface{}, s *session.Session) bool {
+	if roles, ok := router.RouteOption(c, rolesOption).([]string); ok {
+		found := false
+		for _, role := range roles {
+			if s.HasRole(role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	permissions, _ := router.RouteOption(c, permissionsOption).([]string)
+	for _, permission := range permissions {
+		if !s.Can(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAuthorization is pre route function which routes requests not meeting
+// route requirements to 401 page for anonymous users and to 403 page
+// otherwise.
+func checkAuthorization(cv reflect.Value) {
+	c := controller.CMap(cv)
+	if router.ErrorPage(c) || router.RouteOption(c, rolesOption) == nil && router.RouteOption(c, permissionsOption) == nil {
+		return
+	}
+	controller.CheckSession(cv)
+	s, _ := c["session"].(*session.Session)
+	if Authorized(c, s) {
+		return
+	}
+	if s.Anonymous() {
+		router.RouteToError(c, http.StatusUnauthorized)
+	} else {
+		router.RouteToError(c, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/solgar/upendo/database"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+var (
+	// ErrUnknownLogin is returned by credential stores when there is no user
+	// with given login.
+	ErrUnknownLogin = errors.New("Unknown login.")
+
+	credentialStore CredentialStore
+	// used to make verification of unknown logins take as long as known ones
+	dummyCredentials *Credentials
+	dummyOnce        sync.Once
+)
+
+// Credentials holds password hash of a user. Salt is needed only by legacy hex
+// hashes, see security.VerifyPassword.
+type Credentials struct {
+	Login        string
+	PasswordHash string
+	Salt         string
+}
+
+// CredentialStore is the interface used to find password hashes of users.
+type CredentialStore interface {
+	// Credentials returns credentials of user with given login or
+	// ErrUnknownLogin.
+	Credentials(login string) (*Credentials, error)
+}
+
+// PasswordUpdater is implemented by credential stores which can replace
+// password hashes. Outdated hashes are replaced when user logs in.
+type PasswordUpdater interface {
+	UpdatePasswordHash(login, hash string) error
+}
+
+// SetCredentialStore sets store used by Authenticate. By default it's created
+// according to -user-provider setting.
+func SetCredentialStore(store CredentialStore) {
+	credentialStore = store
+}
+
+// NewCredentialStoreFromSettings creates credential store matching
+// -user-provider setting, so users and their passwords are kept together.
+func NewCredentialStoreFromSettings() (CredentialStore, error) {
+	switch settings.UserProvider {
+	case session.UserProviderMemory:
+		return NewMemoryCredentialStore(), nil
+	case "", session.UserProviderSQL:
+		db, err := database.Open(settings.DBDriver, settings.DBDSN)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLCredentialStore(db), nil
+	}
+	return nil, errors.New("Unknown user provider: " + settings.UserProvider)
+}
+
+// VerifyPassword reports if password matches credentials and if its hash
+// should be replaced with one made with current settings.
+func VerifyPassword(c *Credentials, password string) (ok, needsRehash bool, err error) {
+	return security.VerifyPassword(password, c.PasswordHash, c.Salt)
+}
+
+// Authenticate reports if user with given login exists and password matches.
+// Error is returned only if credential store fails. Outdated hash is replaced
+// if the store implements PasswordUpdater.
+func Authenticate(login, password string) (bool, error) {
+	if credentialStore == nil {
+		return false, errors.New("Credential store not set.")
+	}
+	c, err := credentialStore.Credentials(login)
+	if err == ErrUnknownLogin {
+		dummyOnce.Do(func() {
+			hash, _ := security.HashPassword("dummy-password")
+			dummyCredentials = &Credentials{PasswordHash: hash}
+		})
+		VerifyPassword(dummyCredentials, password)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	ok, needsRehash, err := VerifyPassword(c, password)
+	if err != nil || !ok {
+		return false, err
+	}
+	if updater, canUpdate := credentialStore.(PasswordUpdater); needsRehash && canUpdate {
+		if hash, err := security.HashPassword(password); err != nil {
+			fmt.Println("Cannot rehash password:", err)
+		} else if err := updater.UpdatePasswordHash(login, hash); err != nil {
+			fmt.Println("Cannot update password hash:", err)
+		}
+	}
+	return true, nil
+}
+
+// SQLCredentialStore reads password hashes from password and salt columns of
+// User table.
+type SQLCredentialStore struct {
+	db *sql.DB
+}
+
+// NewSQLCredentialStore creates SQLCredentialStore using given database.
+func NewSQLCredentialStore(db *sql.DB) *SQLCredentialStore {
+	return &SQLCredentialStore{db}
+}
+
+func (s *SQLCredentialStore) Credentials(login string) (*Credentials, error) {
+	c := &Credentials{Login: login}
+	err := s.db.QueryRow("SELECT password, salt FROM User WHERE login=?", login).Scan(&c.PasswordHash, &c.Salt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownLogin
+	} else if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// UpdatePasswordHash stores new password hash, legacy salt is cleared.
+func (s *SQLCredentialStore) UpdatePasswordHash(login, hash string) error {
+	_, err := s.db.Exec("UPDATE User SET password=?, salt='' WHERE login=?", hash, login)
+	return err
+}
+
+// MemoryCredentialStore keeps password hashes in memory, it's meant for tests
+// and small applications. Users have to be added to session user provider as
+// well.
+type MemoryCredentialStore struct {
+	mutex       sync.RWMutex
+	credentials map[string]*Credentials
+}
+
+// NewMemoryCredentialStore creates empty MemoryCredentialStore.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{credentials: make(map[string]*Credentials)}
+}
+
+// SetPassword hashes password and stores it for given login.
+func (s *MemoryCredentialStore) SetPassword(login, password string) error {
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.UpdatePasswordHash(login, hash)
+}
+
+// UpdatePasswordHash stores password hash for given login.
+func (s *MemoryCredentialStore) UpdatePasswordHash(login, hash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.credentials[login] = &Credentials{Login: login, PasswordHash: hash}
+	return nil
+}
+
+func (s *MemoryCredentialStore) Credentials(login string) (*Credentials, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, ok := s.credentials[login]
+	if !ok {
+		return nil, ErrUnknownLogin
+	}
+	copied := *c
+	return &copied, nil
+}
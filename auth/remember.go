@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	rememberCookieName = "remember"
+	selectorSize       = 12
+	validatorSize      = 32
+	// how long used token stays valid, so concurrent requests sent with it
+	// before the browser got rotated one don't log user out
+	rotationGrace = time.Minute
+)
+
+var (
+	// ErrTokenNotFound is returned by remember stores when there is no token
+	// with given selector.
+	ErrTokenNotFound = errors.New("Remember me token not found.")
+
+	rememberStore RememberStore = NewMemoryRememberStore()
+)
+
+// RememberToken lets user log in without password. Cookie holds selector and
+// validator, only hash of the validator is stored, so leaked store cannot be
+// used to log in.
+type RememberToken struct {
+	Selector      string
+	ValidatorHash string
+	Login         string
+	Expires       int64
+}
+
+// RememberStore is the interface used to keep remember me tokens.
+type RememberStore interface {
+	Get(selector string) (*RememberToken, error)
+	Save(token *RememberToken) error
+	Delete(selector string) error
+}
+
+// SetRememberStore replaces default in memory store of remember me tokens.
+func SetRememberStore(store RememberStore) {
+	rememberStore = store
+}
+
+// MemoryRememberStore keeps remember me tokens in process memory.
+type MemoryRememberStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*RememberToken
+}
+
+// NewMemoryRememberStore creates empty MemoryRememberStore.
+func NewMemoryRememberStore() *MemoryRememberStore {
+	return &MemoryRememberStore{tokens: make(map[string]*RememberToken)}
+}
+
+func (m *MemoryRememberStore) Get(selector string) (*RememberToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t, ok := m.tokens[selector]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (m *MemoryRememberStore) Save(token *RememberToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *token
+	m.tokens[token.Selector] = &copied
+	return nil
+}
+
+func (m *MemoryRememberStore) Delete(selector string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.tokens, selector)
+	return nil
+}
+
+// remember creates new remember me token for given login and sends its
+// cookie.
+func remember(w http.ResponseWriter, login string) error {
+	selector := security.GenerateTokenHex(selectorSize)
+	validator := security.GenerateTokenHex(validatorSize)
+	expires := time.Now().Add(settings.RememberMeDuration)
+	token := &RememberToken{selector, security.HashToken(validator), login, expires.Unix()}
+	if err := rememberStore.Save(token); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    selector + ":" + validator,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   settings.SessionCookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// forget removes remember me token of the request and its cookie.
+func forget(w http.ResponseWriter, r *http.Request) {
+	if selector, _, ok := rememberCookie(r); ok {
+		if err := rememberStore.Delete(selector); err != nil {
+			fmt.Println("Cannot delete remember me token:", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: rememberCookieName, Value: "", Path: "/", MaxAge: -1})
+}
+
+func rememberCookie(r *http.Request) (selector, validator string, ok bool) {
+	c, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(c.Value, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// checkRememberedToken returns login of valid remember me token sent with the
+// request. Used token expires after short grace period, caller has to issue
+// new one. Token is removed if its validator doesn't match.
+func checkRememberedToken(r *http.Request) (string, bool) {
+	selector, validator, ok := rememberCookie(r)
+	if !ok {
+		return "", false
+	}
+	token, err := rememberStore.Get(selector)
+	if err != nil {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(security.HashToken(validator)), []byte(token.ValidatorHash)) != 1 {
+		if err := rememberStore.Delete(selector); err != nil {
+			fmt.Println("Cannot delete remember me token:", err)
+		}
+		return "", false
+	}
+	now := time.Now()
+	if token.Expires < now.Unix() {
+		return "", false
+	}
+	if grace := now.Add(rotationGrace).Unix(); token.Expires > grace {
+		token.Expires = grace
+		if err := rememberStore.Save(token); err != nil {
+			fmt.Println("Cannot expire remember me token:", err)
+		}
+	}
+	return token.Login, true
+}
+
+// LoginRemembered is pre route function which logs user in if there is no
+// session but request has valid remember me cookie. Token is rotated on every
+// use. Routes without session (see controller.SkipSession) are ignored.
+func LoginRemembered(cv reflect.Value) {
+	if settings.RememberMeDuration <= 0 || session.GetManager() == nil || controller.SessionSkipped(controller.CMap(cv)) {
+		return
+	}
+	controller.CheckSession(cv)
+	if s, _ := controller.CGet(cv, "session").(*session.Session); s != nil && !s.Anonymous() {
+		return
+	}
+
+	r := controller.CGet(cv, "request").(*http.Request)
+	w := controller.CGet(cv, "__writer").(http.ResponseWriter)
+	login, ok := checkRememberedToken(r)
+	if !ok {
+		if _, _, sent := rememberCookie(r); sent {
+			forget(w, r)
+		}
+		return
+	}
+
+	c := controller.CMap(cv)
+	c["login"] = login
+	if _, err := session.GetManager().CreateSession(c); err != nil {
+		fmt.Println("Cannot log in remembered user:", err)
+		forget(w, r)
+		return
+	}
+	if err := remember(w, login); err != nil {
+		fmt.Println("Cannot rotate remember me token:", err)
+	}
+}
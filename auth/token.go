@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	apiKeyIDSize     = 8
+	apiKeySecretSize = 32
+)
+
+var (
+	// ErrUnknownAPIKey is returned by API key stores when there is no key
+	// with given id.
+	ErrUnknownAPIKey = errors.New("Unknown API key.")
+	// ErrInvalidCredentials is returned when API key or bearer token is not
+	// valid.
+	ErrInvalidCredentials = errors.New("Invalid API key or token.")
+
+	apiKeyStore APIKeyStore = NewMemoryAPIKeyStore()
+	jwtKeys     []security.JWTKey
+)
+
+// APIKey lets machine client act as a user. Client sends "<ID>.<secret>" in
+// Authorization header, only hash of the secret is stored.
+type APIKey struct {
+	ID         string
+	SecretHash string
+	Login      string
+	CreatedAt  int64
+}
+
+// APIKeyStore is the interface used to keep API keys.
+type APIKeyStore interface {
+	// APIKey returns key with given id or ErrUnknownAPIKey.
+	APIKey(id string) (*APIKey, error)
+	SaveAPIKey(key *APIKey) error
+	DeleteAPIKey(id string) error
+}
+
+// SetAPIKeyStore replaces default in memory store of API keys.
+func SetAPIKeyStore(store APIKeyStore) {
+	apiKeyStore = store
+}
+
+// MemoryAPIKeyStore keeps API keys in process memory.
+type MemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+// NewMemoryAPIKeyStore creates empty MemoryAPIKeyStore.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]*APIKey)}
+}
+
+func (m *MemoryAPIKeyStore) APIKey(id string) (*APIKey, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return nil, ErrUnknownAPIKey
+	}
+	copied := *k
+	return &copied, nil
+}
+
+func (m *MemoryAPIKeyStore) SaveAPIKey(key *APIKey) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *key
+	m.keys[key.ID] = &copied
+	return nil
+}
+
+func (m *MemoryAPIKeyStore) DeleteAPIKey(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.keys, id)
+	return nil
+}
+
+// CreateAPIKey creates API key for user with given login and returns it. Key
+// has to be shown to the user now, it cannot be retrieved later.
+func CreateAPIKey(login string) (string, error) {
+	id := security.GenerateTokenHex(apiKeyIDSize)
+	secret := security.GenerateToken(apiKeySecretSize)
+	err := apiKeyStore.SaveAPIKey(&APIKey{id, security.HashToken(secret), login, time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+	return id + "." + secret, nil
+}
+
+// RevokeAPIKey deletes API key with given id.
+func RevokeAPIKey(id string) error {
+	return apiKeyStore.DeleteAPIKey(id)
+}
+
+// verifyAPIKey returns login of valid API key.
+func verifyAPIKey(key string) (string, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidCredentials
+	}
+	k, err := apiKeyStore.APIKey(parts[0])
+	if err == ErrUnknownAPIKey {
+		return "", ErrInvalidCredentials
+	} else if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(security.HashToken(parts[1])), []byte(k.SecretHash)) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return k.Login, nil
+}
+
+// SetJWTKeys replaces bearer token keys configured with -jwt-keys. The first
+// key signs new tokens, all of them verify tokens.
+func SetJWTKeys(keys ...security.JWTKey) {
+	jwtKeys = keys
+}
+
+// parseJWTKeys parses "id:secret" pairs of -jwt-keys setting.
+func parseJWTKeys(s string) ([]security.JWTKey, error) {
+	keys := make([]security.JWTKey, 0)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || len(parts[1]) < 16 {
+			return nil, errors.New("Invalid JWT key, \"id:secret\" with secret of at least 16 characters expected.")
+		}
+		keys = append(keys, security.JWTKey{ID: parts[0], Secret: []byte(parts[1])})
+	}
+	return keys, nil
+}
+
+// IssueToken returns bearer token of user with given login, valid for
+// -jwt-token-ttl.
+func IssueToken(login string) (string, error) {
+	if len(jwtKeys) == 0 {
+		return "", errors.New("No JWT keys configured.")
+	}
+	now := time.Now()
+	claims := security.JWTClaims{
+		"sub": login,
+		"iat": now.Unix(),
+		"exp": now.Add(settings.JWTTokenTTL).Unix(),
+		"jti": security.GenerateToken(16),
+	}
+	if settings.JWTIssuer != "" {
+		claims["iss"] = settings.JWTIssuer
+	}
+	if settings.JWTAudience != "" {
+		claims["aud"] = settings.JWTAudience
+	}
+	return security.SignJWT(claims, jwtKeys[0])
+}
+
+// verifyBearerToken returns login of valid bearer token.
+func verifyBearerToken(token string) (string, error) {
+	v := security.JWTValidation{Issuer: settings.JWTIssuer, Audience: settings.JWTAudience, Leeway: time.Minute}
+	claims, err := security.ParseJWT(token, jwtKeys, v)
+	if err != nil {
+		return "", err
+	}
+	if login := claims.String("sub"); login != "" {
+		return login, nil
+	}
+	return "", ErrInvalidCredentials
+}
+
+// machineCredentials returns scheme and credentials of API key or bearer token
+// sent in Authorization header.
+func machineCredentials(r *http.Request) (scheme, credentials string, ok bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	scheme = strings.ToLower(parts[0])
+	if scheme != "bearer" && scheme != "apikey" {
+		return "", "", false
+	}
+	return scheme, strings.TrimSpace(parts[1]), true
+}
+
+// AuthenticateToken is pre route function which authenticates requests with
+// "Authorization: ApiKey <key>" or "Authorization: Bearer <JWT or API key>"
+// header. Session valid only for the request is set under "session" key, so
+// role and permission checks work as for logged in users. Requests with
+// invalid credentials are routed to ErrorsRouting[401].
+func AuthenticateToken(cv reflect.Value) {
+	c := controller.CMap(cv)
+	r := c["request"].(*http.Request)
+	scheme, credentials, ok := machineCredentials(r)
+	smanager := session.GetManager()
+	if !ok || smanager == nil {
+		return
+	}
+
+	var login string
+	var err error
+	if scheme == "bearer" && strings.Count(credentials, ".") == 2 {
+		login, err = verifyBearerToken(credentials)
+	} else {
+		login, err = verifyAPIKey(credentials)
+	}
+	var s *session.Session
+	if err == nil {
+		s, err = smanager.TransientSession(login, r)
+	}
+	if err != nil && router.ErrorPage(c) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Token authentication failed:", err)
+		controller.AddHeader(c, "WWW-Authenticate", `Bearer error="invalid_token"`)
+		router.RouteToError(c, http.StatusUnauthorized)
+		return
+	}
+	c["session"] = s
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/csrf"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// session data key remembering "remember me" choice until second factor
+	// is confirmed
+	rememberPendingKey = "__remember_pending"
+)
+
+var (
+	// ErrNoTwoFactor is returned by two-factor stores when user didn't enable
+	// second factor.
+	ErrNoTwoFactor = errors.New("Two-factor authentication not enabled.")
+	// ErrInvalidCode is returned when TOTP code doesn't confirm enrollment.
+	ErrInvalidCode = errors.New("Invalid code.")
+
+	twoFactorStore TwoFactorStore = NewMemoryTwoFactorStore()
+	// serializes verifications, so the same code can't be used twice
+	// concurrently
+	twoFactorMutex sync.Mutex
+
+	twoFactorForm = template.Must(template.New("2fa").Parse(`<!DOCTYPE html><html><head><title>Confirm login</title></head><body>
+{{if .twoFactorError}}<p>{{.twoFactorError}}</p>{{end}}
+<form method="post" action="{{.twoFactorURL}}">
+<input type="hidden" name="return" value="{{.returnURL}}">
+{{.csrfField}}
+<p><label>Code from authenticator app or recovery code <input type="text" name="code" autocomplete="one-time-code" autofocus></label></p>
+<p><input type="submit" value="Confirm"></p>
+</form>
+</body></html>
+`))
+)
+
+// TwoFactor holds second factor of a user. Recovery codes are kept only as
+// hashes.
+type TwoFactor struct {
+	Login  string
+	Secret string
+	// step of the last accepted TOTP code, older codes are rejected
+	LastStep       int64
+	RecoveryHashes []string
+}
+
+// TwoFactorStore is the interface used to keep second factors of users.
+type TwoFactorStore interface {
+	// TwoFactor returns second factor of user or ErrNoTwoFactor.
+	TwoFactor(login string) (*TwoFactor, error)
+	SaveTwoFactor(t *TwoFactor) error
+	DeleteTwoFactor(login string) error
+}
+
+// SetTwoFactorStore replaces default in memory store of second factors.
+func SetTwoFactorStore(store TwoFactorStore) {
+	twoFactorStore = store
+}
+
+// MemoryTwoFactorStore keeps second factors in process memory.
+type MemoryTwoFactorStore struct {
+	mutex   sync.RWMutex
+	factors map[string]*TwoFactor
+}
+
+// NewMemoryTwoFactorStore creates empty MemoryTwoFactorStore.
+func NewMemoryTwoFactorStore() *MemoryTwoFactorStore {
+	return &MemoryTwoFactorStore{factors: make(map[string]*TwoFactor)}
+}
+
+func (m *MemoryTwoFactorStore) TwoFactor(login string) (*TwoFactor, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	t, ok := m.factors[login]
+	if !ok {
+		return nil, ErrNoTwoFactor
+	}
+	copied := *t
+	copied.RecoveryHashes = append([]string{}, t.RecoveryHashes...)
+	return &copied, nil
+}
+
+func (m *MemoryTwoFactorStore) SaveTwoFactor(t *TwoFactor) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *t
+	copied.RecoveryHashes = append([]string{}, t.RecoveryHashes...)
+	m.factors[t.Login] = &copied
+	return nil
+}
+
+func (m *MemoryTwoFactorStore) DeleteTwoFactor(login string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.factors, login)
+	return nil
+}
+
+// NewTOTPSecret returns new secret and its otpauth:// URI to be shown to the
+// user during enrollment. Second factor isn't enabled until EnableTwoFactor
+// confirms user set it up.
+func NewTOTPSecret(login string) (secret, uri string) {
+	secret = security.GenerateTOTPSecret()
+	return secret, security.TOTPURI(settings.TOTPIssuer, login, secret)
+}
+
+// EnableTwoFactor enables second factor of user if code generated by
+// authenticator app matches the secret. Recovery codes are returned, they have
+// to be shown to the user now, only their hashes are stored.
+func EnableTwoFactor(login, secret, code string) ([]string, error) {
+	step, ok, err := security.VerifyTOTP(secret, code, time.Now(), settings.TOTPWindow, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes := security.GenerateRecoveryCodes(settings.RecoveryCodes)
+	err = twoFactorStore.SaveTwoFactor(&TwoFactor{login, secret, step, hashes})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes second factor of user.
+func DisableTwoFactor(login string) error {
+	return twoFactorStore.DeleteTwoFactor(login)
+}
+
+// TwoFactorEnabled reports if user has to confirm logins with second factor.
+func TwoFactorEnabled(login string) (bool, error) {
+	_, err := twoFactorStore.TwoFactor(login)
+	if err == ErrNoTwoFactor {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// verifySecondFactor checks TOTP or recovery code of user. Used code is
+// recorded, so it can't be used again.
+func verifySecondFactor(login, code string) (bool, error) {
+	twoFactorMutex.Lock()
+	defer twoFactorMutex.Unlock()
+
+	t, err := twoFactorStore.TwoFactor(login)
+	if err != nil {
+		return false, err
+	}
+	step, ok, err := security.VerifyTOTP(t.Secret, code, time.Now(), settings.TOTPWindow, t.LastStep)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		t.LastStep = step
+	} else if t.RecoveryHashes, ok = security.UseRecoveryCode(code, t.RecoveryHashes); !ok {
+		return false, nil
+	}
+	return true, twoFactorStore.SaveTwoFactor(t)
+}
+
+// StartTwoFactor marks current session of user who logged in with password or
+// external provider as waiting for second factor. It has to be called before
+// the session of the user is created, data is carried over to it, so the
+// session is never stored or sent in a cookie without the mark. User should
+// be redirected to -two-factor-url then.
+func StartTwoFactor(c map[string]interface{}, rememberMe bool) {
+	s := controller.Session(c)
+	controller.PanicIfNeeded(s.SetTwoFactorPending(true))
+	if rememberMe {
+		controller.PanicIfNeeded(s.Set(rememberPendingKey, true))
+	}
+}
+
+// checkTwoFactor is pre route function which sends users who didn't confirm
+// second factor yet to second factor page. Only that page and logout are
+// available to them.
+func checkTwoFactor(cv reflect.Value) {
+	if router.ErrorPage(controller.CMap(cv)) {
+		return
+	}
+	controller.CheckSession(cv)
+	if s, _ := controller.CGet(cv, "session").(*session.Session); !s.TwoFactorPending() {
+		return
+	}
+	path := controller.CGet(cv, "path").(string)
+	if path == settings.TwoFactorURL || path == settings.LogoutURL {
+		return
+	}
+
+	r := controller.CGet(cv, "request").(*http.Request)
+	c := controller.CMap(cv)
+	router.Redirect(c, settings.TwoFactorURL+"?return="+url.QueryEscape(r.URL.RequestURI()))
+	router.StopRouting(c)
+}
+
+// TwoFactorPage renders second factor template if there is one, built-in form
+// otherwise. Template gets "twoFactorError", "returnURL", "twoFactorURL" and
+// "csrfField" values.
+func (c Controller) TwoFactorPage() {
+	r := c["request"].(*http.Request)
+	if _, ok := c["returnURL"]; !ok {
+		c["returnURL"] = SafeReturnURL(r.URL.Query().Get("return"))
+	}
+	if s, _ := c["session"].(*session.Session); !s.TwoFactorPending() {
+		router.Redirect(c, c["returnURL"].(string))
+		return
+	}
+	c["twoFactorURL"] = settings.TwoFactorURL
+	c["csrfField"] = csrf.Field(c)
+	c.render(settings.TwoFactorTemplate, twoFactorForm)
+}
+
+// twoFactorFailed renders second factor page again with given error and
+// status code.
+func (c Controller) twoFactorFailed(returnURL, message string, statusCode int) {
+	c["twoFactorError"] = message
+	c["returnURL"] = returnURL
+	c["StatusCode"] = statusCode
+	c.TwoFactorPage()
+}
+
+// TwoFactor checks TOTP or recovery code sent in "code" form field. On success
+// session gets new id and all routes become available, otherwise second factor
+// page is rendered again with 401 status. Failures count towards login
+// lockout.
+func (c Controller) TwoFactor() {
+	r := c["request"].(*http.Request)
+	w := c["__writer"].(http.ResponseWriter)
+	controller.PanicIfNeeded(r.ParseForm())
+	returnURL := SafeReturnURL(r.PostForm.Get("return"))
+
+	s, _ := c["session"].(*session.Session)
+	if !s.TwoFactorPending() {
+		router.Redirect(c, returnURL)
+		return
+	}
+	login := s.UserName
+
+	if loginLockout != nil {
+		left, err := loginLockout.Locked(login)
+		controller.PanicIfNeeded(err)
+		if left > 0 {
+			controller.AddHeader(c, "Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+			c.twoFactorFailed(returnURL, "Too many failed attempts, try again later.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	ok, err := verifySecondFactor(login, r.PostForm.Get("code"))
+	controller.PanicIfNeeded(err)
+	if !ok {
+		fmt.Println("Failed second factor attempt for:", login)
+		if loginLockout != nil {
+			_, err := loginLockout.Fail(login)
+			controller.PanicIfNeeded(err)
+		}
+		c.twoFactorFailed(returnURL, "Invalid code.", http.StatusUnauthorized)
+		return
+	}
+	if loginLockout != nil {
+		controller.PanicIfNeeded(loginLockout.Succeed(login))
+	}
+
+	rememberMe := s.Has(rememberPendingKey)
+	s.Delete(rememberPendingKey)
+	controller.PanicIfNeeded(s.SetTwoFactorPending(false))
+	controller.PanicIfNeeded(session.GetManager().RegenerateID(c))
+	if rememberMe {
+		if err := remember(w, login); err != nil {
+			fmt.Println("Cannot remember login:", err)
+		}
+	}
+	router.Redirect(c, returnURL)
+}
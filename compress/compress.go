@@ -0,0 +1,233 @@
+// Package compress compresses responses using encoding negotiated with
+// Accept-Encoding header. Buffered controller output is gzipped after the
+// handler returns, static resources are served from precompressed sidecar
+// files (style.css.br, style.css.gz) if present and gzipped on the fly
+// otherwise. Only responses of -compress-types at least -compress-min-size
+// long are compressed.
+//
+// Install has to be called after settings are initialized, e.g. with
+// upendo.AddSetupFunc.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/settings"
+)
+
+// Content codings.
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+)
+
+var (
+	enabled bool
+
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, err := gzip.NewWriterLevel(nil, settings.CompressLevel)
+		if err != nil {
+			w = gzip.NewWriter(nil)
+		}
+		return w
+	}}
+)
+
+// Install registers post route function compressing controller output and
+// enables compression of static resources.
+func Install() {
+	enabled = true
+	router.AddPostRouteFunc(Compress)
+}
+
+// Enabled reports if Install was called.
+func Enabled() bool {
+	return enabled
+}
+
+// Negotiate returns coding from offered ones which is accepted by request
+// with the highest quality, earlier offered codings win ties. Empty string is
+// returned if none is acceptable.
+func Negotiate(r *http.Request, offered ...string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, ok := accepted[coding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Compressible reports if responses of given content type are compressed.
+func Compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range strings.Split(settings.CompressTypes, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && (mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddVary adds Accept-Encoding to Vary header of response, so caches keep
+// compressed and uncompressed variants apart.
+func AddVary(c map[string]interface{}) {
+	headers := c["headers"].(map[string]string)
+	if vary := headers["Vary"]; vary != "" {
+		headers["Vary"] = vary + ", Accept-Encoding"
+	} else {
+		headers["Vary"] = "Accept-Encoding"
+	}
+}
+
+// VariantETag returns ETag of response compressed with given coding, it has
+// to differ from ETag of uncompressed response.
+func VariantETag(etag, coding string) string {
+	if !strings.HasSuffix(etag, "\"") {
+		return etag
+	}
+	return strings.TrimSuffix(etag, "\"") + "-" + coding + "\""
+}
+
+// compressibleStatus reports if response with given status has full body,
+// redirects and partial content are never compressed.
+func compressibleStatus(statusCode int) bool {
+	if statusCode == http.StatusNoContent || statusCode == http.StatusPartialContent {
+		return false
+	}
+	return statusCode < 300 || statusCode >= 400
+}
+
+// Compress is post route function which gzips output buffered in "writer"
+// if client accepts it. Content type is detected before compression if
+// controller didn't set it, so it isn't sniffed from compressed data.
+func Compress(cv reflect.Value) {
+	c := controller.CMap(cv)
+	buffer, _ := c["writer"].(*bytes.Buffer)
+	headers := c["headers"].(map[string]string)
+	if buffer == nil || buffer.Len() < settings.CompressMinSize || headers["Content-Encoding"] != "" {
+		return
+	}
+	if statusCode, ok := c["StatusCode"].(int); ok && !compressibleStatus(statusCode) {
+		return
+	}
+
+	contentType, _ := c["Content-Type"].(string)
+	if contentType == "" {
+		contentType = headers["Content-Type"]
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(buffer.Bytes())
+		c["Content-Type"] = contentType
+	}
+	if !Compressible(contentType) {
+		return
+	}
+	AddVary(c)
+	if Negotiate(c["request"].(*http.Request), Gzip) == "" {
+		return
+	}
+
+	compressed := new(bytes.Buffer)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(compressed)
+	_, err := zw.Write(buffer.Bytes())
+	if err == nil {
+		err = zw.Close()
+	}
+	controller.PanicIfNeeded(err)
+
+	c["writer"] = compressed
+	headers["Content-Encoding"] = Gzip
+	if etag := headers["ETag"]; etag != "" {
+		headers["ETag"] = VariantETag(etag, Gzip)
+	}
+}
+
+// ResponseWriter gzips body of successful responses written through it.
+// Content-Length and Accept-Ranges set by the handler are dropped, as they
+// describe uncompressed content. It has to be closed after the response is
+// written.
+type ResponseWriter struct {
+	http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+}
+
+// NewResponseWriter returns ResponseWriter writing to w.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (g *ResponseWriter) WriteHeader(statusCode int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	if statusCode == http.StatusOK {
+		h := g.Header()
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", Gzip)
+		g.zw = gzipWriters.Get().(*gzip.Writer)
+		g.zw.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (g *ResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.zw == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.zw.Write(b)
+}
+
+// Close flushes compressed data.
+func (g *ResponseWriter) Close() error {
+	if g.zw == nil {
+		return nil
+	}
+	err := g.zw.Close()
+	gzipWriters.Put(g.zw)
+	g.zw = nil
+	return err
+}
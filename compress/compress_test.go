@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func request(acceptEncoding string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	return r
+}
+
+func TestNegotiate(t *testing.T) {
+	_t = t
+	assert(Negotiate(request("gzip, deflate, br"), Brotli, Gzip) == Brotli, "The first offered coding should win ties.")
+	assert(Negotiate(request("gzip;q=1.0, br;q=0.5"), Brotli, Gzip) == Gzip, "Quality should be respected.")
+	assert(Negotiate(request("br"), Gzip) == "", "Not accepted coding should not be chosen.")
+	assert(Negotiate(request("*;q=0.1, gzip;q=0"), Brotli, Gzip) == Brotli, "Wildcard should accept other codings.")
+	assert(Negotiate(request(""), Gzip) == "", "No coding should be chosen without Accept-Encoding.")
+}
+
+func TestCompressible(t *testing.T) {
+	_t = t
+	settings.CompressTypes = "text/, application/json"
+	assert(Compressible("text/html; charset=utf-8") && Compressible("application/JSON"), "Listed types should be compressed.")
+	assert(!Compressible("image/png") && !Compressible("application/jsonp") && !Compressible(""), "Other types should not be compressed.")
+}
+
+type testController map[string]interface{}
+
+func (c testController) Page() {
+	c["writer"].(*bytes.Buffer).WriteString("<html>" + strings.Repeat("upendo ", 200) + "</html>")
+	c["headers"].(map[string]string)["ETag"] = `"v1"`
+}
+
+func (c testController) Small() {
+	c["writer"].(*bytes.Buffer).WriteString("<html>small</html>")
+}
+
+func TestCompress(t *testing.T) {
+	_t = t
+	settings.CompressTypes, settings.CompressMinSize, settings.CompressLevel = "text/", 100, 6
+	settings.RoutingChainMax = 4
+	router.Add("GET", "/page", testController{}, "Page")
+	router.Add("GET", "/small", testController{}, "Small")
+	router.AddPostRouteFunc(Compress)
+
+	r := httptest.NewRequest("GET", "/page", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	assert(w.Header().Get("Content-Encoding") == "gzip" && w.Header().Get("Vary") == "Accept-Encoding", "Page should be gzipped.")
+	assert(strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") && w.Header().Get("ETag") == `"v1-gzip"`, "Type should be detected before compression and ETag changed.")
+	zr, err := gzip.NewReader(w.Body)
+	assert(err == nil, "Body should be gzip stream.")
+	body, _ := ioutil.ReadAll(zr)
+	assert(strings.HasPrefix(string(body), "<html>upendo "), "Body should decompress to the page.")
+
+	w = httptest.NewRecorder()
+	router.RouteRequest(w, httptest.NewRequest("GET", "/page", nil))
+	assert(w.Header().Get("Content-Encoding") == "" && w.Header().Get("Vary") == "Accept-Encoding", "Page should not be gzipped for client not accepting it.")
+
+	r = httptest.NewRequest("GET", "/small", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	assert(w.Header().Get("Content-Encoding") == "" && w.Body.String() == "<html>small</html>", "Small page should not be compressed.")
+}
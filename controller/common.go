@@ -13,18 +13,20 @@ var (
 	templates = make(map[string]*pages.Page)
 )
 
+// HandlePageTemplate renders page template with given name to controller
+// output, within its layout if the page declares one.
 func HandlePageTemplate(controller interface{}, template string) {
-	if settings.ReloadTemplates {
+	if settings.ReloadTemplates && pages.TemplatesReloadable() {
 		pages.LoadTemplates(settings.TemplatesDir)
 	}
 
 	buff := reflect.ValueOf(controller).MapIndex(reflect.ValueOf("writer")).Interface().(*bytes.Buffer)
 
 	if pages.TemplatesRoot == nil {
-		panic("TemplatesRoot is nil! Probably no templates found in directory: " + settings.StartDir + settings.TemplatesDir)
+		panic("TemplatesRoot is nil! Probably no templates found in directory: " + settings.TemplatesDir)
 	}
 
-	err := pages.TemplatesRoot.ExecuteTemplate(buff, template, controller)
+	err := pages.ExecutePage(buff, template, controller)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
@@ -9,31 +10,71 @@ import (
 	"github.com/solgar/upendo/session"
 )
 
-var (
-	smanager *session.Manager = session.GetManager()
+const (
+	// route option set by SkipSession
+	noSessionOption = "controller.noSession"
 )
 
 func RegisterPreRouteFunctions() {
 	router.AddPreRouteFunc(CheckSession)
 	router.AddPreRouteFunc(CheckCookies)
+	router.AddPostRouteFunc(StoreSession)
 }
 
 func CSet(cv reflect.Value, k string, v interface{}) {
 	cv.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(v))
 }
 
+// CMap returns controller as a map, so it can be passed to functions taking
+// map[string]interface{} like router.Redirect.
+func CMap(cv reflect.Value) map[string]interface{} {
+	return cv.Convert(reflect.TypeOf(map[string]interface{}{})).Interface().(map[string]interface{})
+}
+
+// CGet returns controller value for given key or nil if there is no such key.
 func CGet(cv reflect.Value, k string) interface{} {
-	return cv.MapIndex(reflect.ValueOf(k)).Interface()
+	v := cv.MapIndex(reflect.ValueOf(k))
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
 }
 
+// SkipSession makes CheckSession ignore route added with router.Add, e.g.
+// static files which never need a session.
+func SkipSession(method, path string) {
+	router.SetRouteOption(method, path, noSessionOption, true)
+}
+
+// SessionSkipped reports if request doesn't use session, because its route
+// was marked with SkipSession or it's a request for static file.
+func SessionSkipped(c map[string]interface{}) bool {
+	if router.RouteOption(c, noSessionOption) != nil {
+		return true
+	}
+	path, _ := c["path"].(string)
+	return strings.HasPrefix(path, "/css") || strings.HasPrefix(path, "/favico") || strings.HasPrefix(path, "/res") || strings.HasPrefix(path, "/js")
+}
+
+// CheckSession sets current session under "session" key. Session is looked up
+// only once per request, so pre route functions which need it can call
+// CheckSession on their own.
 func CheckSession(controller reflect.Value) {
-	path := CGet(controller, "path").(string)
+	if controller.MapIndex(reflect.ValueOf("session")).IsValid() {
+		return
+	}
+	if SessionSkipped(CMap(controller)) {
+		return
+	}
 
-	if strings.HasPrefix(path, "/css") || strings.HasPrefix(path, "/favico") || strings.HasPrefix(path, "/res") || strings.HasPrefix(path, "/js") {
+	// session manager is optional, it's started with session.Initialize
+	smanager := session.GetManager()
+	if smanager == nil {
 		return
 	}
 
 	session := smanager.GetSession(CGet(controller, "request").(*http.Request))
+	smanager.RefreshCookie(CGet(controller, "__writer").(http.ResponseWriter), session)
 	CSet(controller, "session", session)
 }
 
@@ -46,3 +87,29 @@ func CheckCookies(controller reflect.Value) {
 	}
 
 }
+
+// StoreSession saves session if its data was modified by handler.
+func StoreSession(controller reflect.Value) {
+	smanager := session.GetManager()
+	s, _ := CGet(controller, "session").(*session.Session)
+	if smanager == nil || s == nil {
+		return
+	}
+
+	if err := smanager.SaveSession(CGet(controller, "__writer").(http.ResponseWriter), s); err != nil {
+		fmt.Println("Cannot save session:", err)
+	}
+}
+
+// Session returns current session. If there is none, new anonymous session is
+// created, it's stored after the handler returns only if some data was set in
+// it.
+func Session(c map[string]interface{}) *session.Session {
+	s, _ := c["session"].(*session.Session)
+	smanager := session.GetManager()
+	if s == nil && smanager != nil {
+		s = smanager.NewSession(c["request"].(*http.Request))
+		c["session"] = s
+	}
+	return s
+}
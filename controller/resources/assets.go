@@ -0,0 +1,181 @@
+package resources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/compress"
+	"github.com/solgar/upendo/settings"
+)
+
+// asset is static file available at content-hashed URL.
+type asset struct {
+	// filesystem and name of the file, fsys is nil for prebuilt assets from
+	// manifest which are served under their own names
+	fsys    fs.FS
+	name    string
+	logical string
+	url     string
+	modTime time.Time
+	size    int64
+}
+
+var (
+	assetsMutex sync.RWMutex
+	// assets by logical URL, e.g. "/css/site.css"
+	assets = make(map[string]*asset)
+	// assets by content-hashed URL, e.g. "/css/site.3f2a9c1d0b4e.css"
+	fingerprinted = make(map[string]*asset)
+)
+
+// Asset returns content-hashed URL of static file with given logical URL,
+// e.g. "/css/site.3f2a9c1d0b4e.css" for "css/site.css". Such URLs are served
+// with -asset-cache-control, so clients keep files until they change. Names of
+// unknown files are returned unchanged. It's available in templates as
+// "asset" function:
+//
+//	<link rel="stylesheet" href="{{asset "css/site.css"}}">
+//
+// With -reload-templates files are hashed again when they change.
+func Asset(name string) string {
+	logical := "/" + strings.TrimPrefix(name, "/")
+	assetsMutex.RLock()
+	a, ok := assets[logical]
+	assetsMutex.RUnlock()
+	if !ok {
+		return name
+	}
+
+	if settings.ReloadTemplates && a.fsys != nil {
+		info, err := fs.Stat(a.fsys, a.name)
+		if err == nil && (!info.ModTime().Equal(a.modTime) || info.Size() != a.size) {
+			if a, err = addAsset(logical, a.fsys, a.name); err != nil {
+				fmt.Println("Error:", err)
+				return name
+			}
+		}
+	}
+	return a.url
+}
+
+// fingerprint inserts hash into file name before its extension.
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// addAsset hashes file of fsys and makes it available at content-hashed URL
+// next to logical one.
+func addAsset(logical string, fsys fs.FS, name string) (*asset, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	a := &asset{fsys, name, logical, fingerprint(logical, hex.EncodeToString(sum[:6])), info.ModTime(), info.Size()}
+
+	assetsMutex.Lock()
+	defer assetsMutex.Unlock()
+	if old, ok := assets[logical]; ok {
+		delete(fingerprinted, old.url)
+	}
+	assets[logical] = a
+	fingerprinted[a.url] = a
+	return a, nil
+}
+
+// fingerprintAll hashes files of filesystem mounted at prefix. Hidden files
+// and precompressed sidecars are skipped, missing filesystem root is ignored.
+func fingerprintAll(prefix string, fsys fs.FS) {
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == "." && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || path.Ext(name) == codingExtensions[compress.Brotli] || path.Ext(name) == codingExtensions[compress.Gzip] {
+			return nil
+		}
+		_, err = addAsset(strings.TrimSuffix(prefix, "/")+"/"+name, fsys, name)
+		return err
+	})
+	if err != nil {
+		fmt.Println("Error: cannot fingerprint assets of "+prefix+":", err)
+	}
+}
+
+// LoadManifest reads JSON manifest of prebuilt fingerprinted assets, which
+// maps logical URLs to URLs of fingerprinted files, e.g.
+// {"/css/site.css": "/css/site.3f2a9c1d.css"}. Fingerprinted files have to be
+// served from mounted directories, they get -asset-cache-control too.
+// Absolute URLs (e.g. of CDN) are returned by Asset as they are.
+func LoadManifest(fsys fs.FS, name string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	manifest := make(map[string]string)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return errors.New("Invalid asset manifest " + name + ": " + err.Error())
+	}
+
+	assetsMutex.Lock()
+	defer assetsMutex.Unlock()
+	for logical, url := range manifest {
+		if !strings.Contains(url, "://") {
+			url = "/" + strings.TrimPrefix(url, "/")
+		}
+		a := &asset{url: url}
+		assets["/"+strings.TrimPrefix(logical, "/")] = a
+		fingerprinted[url] = a
+	}
+	return nil
+}
+
+// current reports if file of asset still has content matching hash in its
+// URL. File which was modified is hashed again, so Asset returns its new URL.
+func (a *asset) current() bool {
+	if a.fsys == nil {
+		return true
+	}
+	info, err := fs.Stat(a.fsys, a.name)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return true
+	}
+	fresh, err := addAsset(a.logical, a.fsys, a.name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	return fresh.url == a.url
+}
+
+// fingerprintedAsset returns asset served at given URL path.
+func fingerprintedAsset(url string) (*asset, bool) {
+	assetsMutex.RLock()
+	defer assetsMutex.RUnlock()
+	a, ok := fingerprinted[url]
+	return a, ok
+}
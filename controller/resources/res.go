@@ -1,78 +1,297 @@
 package resources
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 
+	"github.com/solgar/upendo/compress"
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/pages"
 	"github.com/solgar/upendo/router"
 	"github.com/solgar/upendo/settings"
 )
 
-// Resources is simple controller to handle resources at paths /res/* /css/* and /js/*
+const (
+	// route option holding filesystem of mounted prefix
+	filesystemOption = "resources.filesystem"
+)
+
+var (
+	// extensions of precompressed sidecar files
+	codingExtensions = map[string]string{compress.Brotli: ".br", compress.Gzip: ".gz"}
+	// content based ETags of files without modification time (embed.FS)
+	contentTags sync.Map
+)
+
+// Resources is simple controller to handle static files. Directories are
+// mapped to URL prefixes with -static-dirs setting, Mount or MountFS, by
+// default /res/*, /css/* and /js/* are served.
 type Resources map[string]interface{}
 
+// contentKey identifies file in contentTags.
+type contentKey struct {
+	fsys fs.FS
+	name string
+}
+
+// Install mounts directories configured with -static-dirs.
 func Install() {
-	router.Add("GET", "/res/:file", Resources{}, "ImageResource")
-	router.Add("GET", "/css/:file", Resources{}, "CSSResource")
-	router.Add("GET", "/js/:file", Resources{}, "JSResource")
+	for _, pair := range strings.Split(settings.StaticDirs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			panic("Invalid static directory, \"/prefix=directory\" expected: " + pair)
+		}
+		Mount(parts[0], parts[1])
+	}
+	if settings.AssetManifest != "" {
+		dir, file := filepath.Split(settings.AssetManifest)
+		controller.PanicIfNeeded(LoadManifest(dirFS(dir), file))
+	}
+	pages.RegisterFunction("asset", Asset)
 }
 
-func (c Resources) SendResource() {
-	file := c["file"].(string)
-	if settings.IgnoreMapFiles && strings.HasSuffix(file, ".map") {
-		return
+// Mount serves files from directory at paths starting with prefix, e.g. file
+// "static/img/logo.png" is served at "/assets/img/logo.png" after
+// Mount("/assets", "static"). Relative directories are relative to start
+// directory.
+func Mount(prefix, directory string) {
+	MountFS(prefix, dirFS(directory))
+}
+
+// MountFS serves files from fsys at paths starting with prefix. With
+// -fingerprint-assets files are hashed now and served also at content-hashed
+// URLs returned by Asset. Use it to serve files compiled into the binary, e.g.
+// with embed.FS and fs.Sub:
+//
+//	//go:embed static
+//	var static embed.FS
+//	sub, _ := fs.Sub(static, "static")
+//	resources.MountFS("/assets", sub)
+func MountFS(prefix string, fsys fs.FS) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	for _, method := range []string{"GET", "HEAD"} {
+		router.Add(method, prefix+"/*file", Resources{}, "Serve")
+		router.SetRouteOption(method, prefix+"/*file", filesystemOption, fsys)
+		controller.SkipSession(method, prefix+"/*file")
 	}
+	if settings.FingerprintAssets {
+		fingerprintAll(prefix, fsys)
+	}
+}
 
-	w := c["writer"].(*bytes.Buffer)
-	directory := c["directory"].(string)
+// dirFS returns filesystem of directory on disk, relative directories are
+// relative to start directory.
+func dirFS(directory string) fs.FS {
+	if !filepath.IsAbs(directory) {
+		directory = settings.StartDir + directory
+	}
+	if directory == "" {
+		directory = "."
+	}
+	return os.DirFS(directory)
+}
 
-	f, err := os.Open(settings.StartDir + directory + "/" + file)
-	defer f.Close()
+// resolve returns name of file in filesystem. Paths leaving the filesystem
+// and hidden files (e.g. .git or .env) are rejected.
+func resolve(file string) (string, bool) {
+	if strings.ContainsAny(file, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(file, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+file), "/")
+	if name == "" {
+		return ".", true
+	}
+	return name, true
+}
 
+// etag returns validator of file content, it changes when file is modified.
+// Files without modification time, e.g. embedded ones, are validated with
+// hash of their content.
+func etag(fsys fs.FS, name string, info fs.FileInfo) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()), nil
+	}
+	// filesystems like fstest.MapFS can't be map keys
+	cacheable := reflect.TypeOf(fsys).Comparable()
+	if cacheable {
+		if tag, ok := contentTags.Load(contentKey{fsys, name}); ok {
+			return tag.(string), nil
+		}
+	}
+	data, err := fs.ReadFile(fsys, name)
 	if err != nil {
-		fmt.Println("Error:", err)
-		router.RedirectToError(c, http.StatusNotFound)
-		return
+		return "", err
 	}
+	sum := sha256.Sum256(data)
+	tag := fmt.Sprintf("\"%x\"", sum[:12])
+	if cacheable {
+		contentTags.Store(contentKey{fsys, name}, tag)
+	}
+	return tag, nil
+}
 
-	var fbytes []byte
-	fbytes, err = ioutil.ReadAll(f)
+// Serve handles routes added by Mount and MountFS. Files requested at
+// content-hashed URLs are sent with -asset-cache-control.
+func (c Resources) Serve() {
+	c["fs"] = router.RouteOption(c, filesystemOption).(fs.FS)
+	if a, ok := fingerprintedAsset(c["path"].(string)); ok {
+		if !a.current() {
+			router.RouteToError(c, http.StatusNotFound)
+			return
+		}
+		if a.fsys != nil {
+			c["fs"], c["file"] = a.fsys, a.name
+		}
+		c["__cacheControl"] = settings.AssetCacheControl
+	}
+	c.SendResource()
+}
 
-	if err != nil {
-		fmt.Println("Error:", err)
-		router.RedirectToError(c, http.StatusInternalServerError)
+// SendResource sends file c["file"] from filesystem c["fs"] or, if it isn't
+// set, from directory c["directory"] on disk, relative directory is relative
+// to start directory. Content type is detected from extension or content,
+// conditional (If-None-Match, If-Modified-Since) and range requests are
+// supported. If compress package is installed, precompressed sidecar file is
+// sent or file is gzipped on the fly. Uncompressed file from disk is copied to
+// connection by the kernel when possible, it's never read into memory.
+func (c Resources) SendResource() {
+	fsys, ok := c["fs"].(fs.FS)
+	if !ok {
+		fsys = dirFS(c["directory"].(string))
+	}
+	file := c["file"].(string)
+	name, ok := resolve(file)
+	if !ok {
+		router.RouteToError(c, http.StatusNotFound)
 		return
 	}
 
-	writer := bufio.NewWriter(w)
-	_, err = writer.Write(fbytes)
-	writer.Flush()
-
-	if err != nil {
-		fmt.Println("Error:", err)
+	info, err := fs.Stat(fsys, name)
+	if err != nil || info.IsDir() {
+		if err != nil && !(errors.Is(err, fs.ErrNotExist) && settings.IgnoreMapFiles && strings.HasSuffix(file, ".map")) {
+			fmt.Println("Error:", err)
+		}
+		router.RouteToError(c, http.StatusNotFound)
 		return
 	}
+
+	cacheControl, ok := c["__cacheControl"].(string)
+	if !ok {
+		cacheControl = settings.StaticCacheControl
+	}
+	if cacheControl != "" {
+		controller.AddHeader(c, "Cache-Control", cacheControl)
+	}
+	coding, sidecar := c.negotiateCoding(fsys, name, info)
+	if sidecar != nil {
+		name, info = name+codingExtensions[coding], sidecar
+	}
+	tag, err := etag(fsys, name, info)
+	controller.PanicIfNeeded(err)
+	if coding != "" {
+		tag = compress.VariantETag(tag, coding)
+		controller.AddHeader(c, "Content-Encoding", coding)
+	}
+	controller.AddHeader(c, "ETag", tag)
+
+	router.ServeWith(c, func(w http.ResponseWriter, r *http.Request) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			fmt.Println("Error:", err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				fmt.Println("Error:", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			content = bytes.NewReader(data)
+		}
+		if coding != "" && sidecar == nil {
+			gw := compress.NewResponseWriter(w)
+			defer gw.Close()
+			w = gw
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+	})
+}
+
+// negotiateCoding chooses content coding of file if compression is enabled.
+// Precompressed sidecar file (name.br or name.gz) is preferred, its info is
+// returned then. Otherwise file may be gzipped on the fly, unless range of it
+// was requested.
+func (c Resources) negotiateCoding(fsys fs.FS, name string, info fs.FileInfo) (string, fs.FileInfo) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if !compress.Enabled() || !compress.Compressible(contentType) {
+		return "", nil
+	}
+	// compressed variant has no type to sniff from
+	c["Content-Type"] = contentType
+	compress.AddVary(c)
+
+	r := c["request"].(*http.Request)
+	sidecars := make(map[string]fs.FileInfo)
+	offered := make([]string, 0, 2)
+	for _, coding := range []string{compress.Brotli, compress.Gzip} {
+		// outdated sidecars are ignored
+		s, err := fs.Stat(fsys, name+codingExtensions[coding])
+		if err == nil && s.Mode().IsRegular() && !s.ModTime().Before(info.ModTime()) {
+			sidecars[coding] = s
+			offered = append(offered, coding)
+		}
+	}
+	onTheFly := sidecars[compress.Gzip] == nil && info.Size() >= int64(settings.CompressMinSize) && r.Header.Get("Range") == ""
+	if onTheFly {
+		offered = append(offered, compress.Gzip)
+	}
+	coding := compress.Negotiate(r, offered...)
+	if coding == "" {
+		return "", nil
+	}
+	return coding, sidecars[coding]
 }
 
+// ImageResource serves c["file"] from "res" directory.
 func (c Resources) ImageResource() {
-	// fix: set content type
 	c["directory"] = "res"
 	c.SendResource()
 }
 
+// CSSResource serves c["file"] from "css" directory.
 func (c Resources) CSSResource() {
-	c["Content-Type"] = "text/css"
 	c["directory"] = "css"
 	c.SendResource()
 }
 
+// JSResource serves c["file"] from "js" directory.
 func (c Resources) JSResource() {
-	c["Content-Type"] = "application/javascript"
 	c["directory"] = "js"
 	c.SendResource()
 }
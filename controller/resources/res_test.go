@@ -0,0 +1,185 @@
+package resources
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/solgar/upendo/compress"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func get(path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	return w
+}
+
+func TestResolve(t *testing.T) {
+	_t = t
+	name, ok := resolve("img//logo.png")
+	assert(ok && name == "img/logo.png", "File inside directory should be resolved.")
+	for _, file := range []string{"../secret", "img/../../secret", ".env", "img/.git/config", "a\\..\\b", "a\x00b"} {
+		_, ok = resolve(file)
+		assert(!ok, "Path should be rejected: "+file)
+	}
+}
+
+func TestServe(t *testing.T) {
+	_t = t
+	dir, err := ioutil.TempDir("", "static")
+	assert(err == nil, "Temp dir should be created.")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "img"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "img", "logo.png"), []byte("\x89PNG\r\n\x1a\nrest of image"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "site.css"), []byte("body { margin: 0 }"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0644)
+	settings.StaticCacheControl, settings.RoutingChainMax = "public, max-age=60", 4
+	Mount("/assets/", dir)
+
+	w := get("/assets/img/logo.png")
+	assert(w.Code == http.StatusOK && w.Header().Get("Content-Type") == "image/png", "Image should be served with its type.")
+	assert(w.Header().Get("Cache-Control") == "public, max-age=60" && w.Header().Get("Last-Modified") != "", "Cache headers should be sent.")
+	etag := w.Header().Get("ETag")
+
+	w = get("/assets/img/logo.png", "If-None-Match", etag)
+	assert(w.Code == http.StatusNotModified && w.Body.Len() == 0, "Unchanged file should not be sent again.")
+
+	w = get("/assets/site.css", "Range", "bytes=0-3")
+	assert(w.Code == http.StatusPartialContent && w.Body.String() == "body", "Range should be served.")
+	assert(w.Header().Get("Content-Type") == "text/css; charset=utf-8", "Wrong content type of CSS.")
+
+	assert(get("/assets/missing.css").Code == http.StatusNotFound, "Missing file should not be found.")
+	assert(get("/assets/img").Code == http.StatusNotFound, "Directory should not be served.")
+	assert(get("/assets/.env").Code == http.StatusNotFound, "Hidden file should not be served.")
+	assert(get("/assets/img/../../etc/passwd").Code == http.StatusNotFound, "Path leaving directory should not be served.")
+}
+
+func TestStartDir(t *testing.T) {
+	_t = t
+	dir, err := ioutil.TempDir("", "start")
+	assert(err == nil, "Temp dir should be created.")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "app", "css"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "app", "css", "site.css"), []byte("body { margin: 0 }"), 0644)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	startDir := settings.StartDir
+	settings.StartDir, settings.RoutingChainMax = "app/", 4
+	defer func() { settings.StartDir = startDir }()
+	router.Add("GET", "/app/css/:file", Resources{}, "CSSResource")
+
+	w := get("/app/css/site.css")
+	assert(w.Code == http.StatusOK && w.Body.String() == "body { margin: 0 }", "File should be served from directory in start directory.")
+}
+
+func TestServeFS(t *testing.T) {
+	_t = t
+	fsys := fstest.MapFS{
+		"site.css":     {Data: []byte("body { margin: 0 }")},
+		"app.js":       {Data: []byte("let a = 1")},
+		"img/logo.svg": {Data: []byte("<svg></svg>")},
+	}
+	settings.RoutingChainMax = 4
+	MountFS("/embedded", fsys)
+
+	w := get("/embedded/site.css")
+	assert(w.Code == http.StatusOK && w.Body.String() == "body { margin: 0 }", "File from filesystem should be served.")
+	etag := w.Header().Get("ETag")
+	assert(etag != "" && etag != get("/embedded/app.js").Header().Get("ETag"), "Files without modification time should get content ETags.")
+	w = get("/embedded/site.css", "If-None-Match", etag)
+	assert(w.Code == http.StatusNotModified, "Unchanged file should not be sent again.")
+	w = get("/embedded/img/logo.svg", "Range", "bytes=1-3")
+	assert(w.Code == http.StatusPartialContent && w.Body.String() == "svg", "Range should be served.")
+	assert(get("/embedded/missing.js").Code == http.StatusNotFound, "Missing file should not be found.")
+}
+
+func TestAssets(t *testing.T) {
+	_t = t
+	fsys := fstest.MapFS{
+		"site.css":           {Data: []byte("body { margin: 0 }")},
+		"site.css.gz":        {Data: []byte("gzip data")},
+		"app.4f1e2d.js":      {Data: []byte("let a = 1")},
+		".hidden/secret.txt": {Data: []byte("secret")},
+		"manifest.json":      {Data: []byte(`{"static/app.js": "/static/app.4f1e2d.js", "/vendor.js": "https://cdn.example.com/vendor.1.js"}`)},
+	}
+	settings.RoutingChainMax, settings.FingerprintAssets = 4, true
+	settings.StaticCacheControl, settings.AssetCacheControl = "public, max-age=60", "public, immutable"
+	defer func() { settings.FingerprintAssets = false }()
+	MountFS("/static", fsys)
+
+	url := Asset("static/site.css")
+	assert(strings.HasPrefix(url, "/static/site.") && strings.HasSuffix(url, ".css") && url != "/static/site.css", "Asset URL should contain hash of content: "+url)
+	assert(Asset("/static/site.css") == url, "Leading slash of asset name should be optional.")
+	w := get(url)
+	assert(w.Code == http.StatusOK && w.Body.String() == "body { margin: 0 }", "Asset should be served at hashed URL.")
+	assert(w.Header().Get("Cache-Control") == "public, immutable", "Hashed URL should be cached for long.")
+	assert(get("/static/site.css").Header().Get("Cache-Control") == "public, max-age=60", "Logical URL should be cached as other static files.")
+	assert(Asset("static/site.css.gz") == "static/site.css.gz" && Asset("static/.hidden/secret.txt") == "static/.hidden/secret.txt", "Sidecars and hidden files should not be fingerprinted.")
+	assert(Asset("static/missing.css") == "static/missing.css", "Unknown asset should be returned unchanged.")
+
+	fsys["site.css"].Data, fsys["site.css"].ModTime = []byte("body { margin: 1em }"), time.Now()
+	assert(get(url).Code == http.StatusNotFound, "Changed asset should not be served at old hashed URL.")
+	changed := Asset("static/site.css")
+	assert(changed != url && get(changed).Body.String() == "body { margin: 1em }", "Changed asset should be served at new hashed URL.")
+
+	assert(LoadManifest(fsys, "manifest.json") == nil, "Manifest should be loaded.")
+	assert(Asset("static/app.js") == "/static/app.4f1e2d.js", "Prebuilt asset should be taken from manifest.")
+	assert(Asset("vendor.js") == "https://cdn.example.com/vendor.1.js", "Absolute URLs from manifest should be kept.")
+	w = get("/static/app.4f1e2d.js")
+	assert(w.Body.String() == "let a = 1" && w.Header().Get("Cache-Control") == "public, immutable", "Prebuilt asset should be cached for long.")
+}
+
+func TestServeCompressed(t *testing.T) {
+	_t = t
+	dir, _ := ioutil.TempDir("", "static")
+	defer os.RemoveAll(dir)
+	css := strings.Repeat("body { margin: 0 }\n", 100)
+	ioutil.WriteFile(filepath.Join(dir, "site.css"), []byte(css), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "site.css.br"), []byte("brotli data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "logo.png"), []byte(strings.Repeat("\x89PNG", 500)), 0644)
+	settings.RoutingChainMax, settings.CompressMinSize, settings.CompressTypes = 4, 100, "text/"
+	compress.Install()
+	Mount("/packed", dir)
+
+	w := get("/packed/site.css", "Accept-Encoding", "gzip, br")
+	assert(w.Header().Get("Content-Encoding") == "br" && w.Body.String() == "brotli data", "Brotli sidecar should be served.")
+	assert(w.Header().Get("Content-Type") == "text/css; charset=utf-8" && w.Header().Get("Vary") == "Accept-Encoding", "Type of original file and Vary should be sent.")
+	brETag := w.Header().Get("ETag")
+
+	w = get("/packed/site.css", "Accept-Encoding", "gzip")
+	zr, err := gzip.NewReader(w.Body)
+	assert(err == nil && w.Header().Get("Content-Encoding") == "gzip", "CSS should be gzipped on the fly without gzip sidecar.")
+	body, _ := ioutil.ReadAll(zr)
+	assert(string(body) == css && w.Header().Get("ETag") != brETag, "Gzipped CSS should have own ETag.")
+
+	w = get("/packed/site.css", "Accept-Encoding", "gzip", "Range", "bytes=0-3")
+	assert(w.Code == http.StatusPartialContent && w.Body.String() == "body", "Range should be served uncompressed.")
+	w = get("/packed/site.css")
+	assert(w.Header().Get("Content-Encoding") == "" && w.Body.String() == css, "Uncompressed CSS should be served to other clients.")
+	w = get("/packed/logo.png", "Accept-Encoding", "gzip")
+	assert(w.Header().Get("Content-Encoding") == "" && w.Header().Get("Vary") == "", "Image should not be compressed.")
+}
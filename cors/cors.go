@@ -0,0 +1,206 @@
+// Package cors allows cross-origin requests to groups of routes. Policies are
+// applied by router before requests reach controllers, preflight requests are
+// answered automatically:
+//
+//	cors.Allow("/api/", cors.Policy{Origins: []string{"https://*.example.com"}, Credentials: true})
+//
+// Default policy for all paths can be configured with -cors-* settings.
+package cors
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// preflight response depends on these request headers
+	preflightVary = "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"
+)
+
+var (
+	policiesMutex sync.RWMutex
+	policies      = make(map[string]*Policy)
+	// prefixes sorted from the longest one
+	prefixes    []string
+	installOnce sync.Once
+)
+
+// Policy describes which cross-origin requests are allowed.
+type Policy struct {
+	// allowed origins, "*" matches any origin, "https://*.example.com"
+	// matches subdomains
+	Origins []string
+	// allowed methods, GET, HEAD and POST if empty
+	Methods []string
+	// allowed request headers, "*" allows any
+	Headers []string
+	// response headers available to scripts
+	ExposedHeaders []string
+	// if requests can carry cookies, can't be combined with "*" origin
+	Credentials bool
+	// how long browsers can cache preflight response
+	MaxAge time.Duration
+}
+
+// Install applies default policy configured with -cors-* settings to all paths
+// if -cors-origins is set. It has to be called after settings are initialized.
+func Install() {
+	if settings.CORSOrigins != "" {
+		Allow("/", Policy{
+			Origins:        splitList(settings.CORSOrigins),
+			Methods:        splitList(settings.CORSMethods),
+			Headers:        splitList(settings.CORSHeaders),
+			ExposedHeaders: splitList(settings.CORSExposedHeaders),
+			Credentials:    settings.CORSCredentials,
+			MaxAge:         settings.CORSMaxAge,
+		})
+	}
+}
+
+// Allow applies policy to paths starting with prefix. Policy of the longest
+// matching prefix is used. Request filter is registered on first call. It
+// panics if policy allows credentials from any origin, that would let every
+// site read responses of logged in users.
+func Allow(prefix string, policy Policy) {
+	if policy.Credentials && policy.anyOrigin() {
+		panic("CORS policy of " + prefix + " can't allow credentials from any origin, list allowed origins instead of \"*\".")
+	}
+	installOnce.Do(func() {
+		router.AddRequestFilter(Filter)
+	})
+	if len(policy.Methods) == 0 {
+		policy.Methods = []string{"GET", "HEAD", "POST"}
+	}
+
+	policiesMutex.Lock()
+	defer policiesMutex.Unlock()
+	if _, ok := policies[prefix]; !ok {
+		prefixes = append(prefixes, prefix)
+		sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	}
+	policies[prefix] = &policy
+}
+
+func splitList(s string) []string {
+	result := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func policyFor(path string) *Policy {
+	policiesMutex.RLock()
+	defer policiesMutex.RUnlock()
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return policies[prefix]
+		}
+	}
+	return nil
+}
+
+// OriginAllowed reports if origin matches any of allowed origins.
+func (p *Policy) OriginAllowed(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if star := strings.Index(allowed, "*"); star != -1 {
+			prefix, suffix := allowed[:star], allowed[star+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == "*" || strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyOrigin reports if policy allows all origins with "*".
+func (p *Policy) anyOrigin() bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets headers common to preflight and actual responses.
+func (p *Policy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Filter is request filter applying policies. Preflight requests are answered
+// with 204, or 403 if they aren't allowed. Actual requests get CORS headers and
+// are routed normally. Responses of policies echoing origin vary by it, also
+// those sent to other origins, so caches don't mix them up.
+func Filter(w http.ResponseWriter, r *http.Request) bool {
+	p := policyFor(r.URL.Path)
+	if p == nil {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	requestedMethod := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != http.MethodOptions || requestedMethod == "" || origin == "" {
+		if !p.anyOrigin() {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin != "" && p.OriginAllowed(origin) {
+			p.allowOrigin(w.Header(), origin)
+			if len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+		return false
+	}
+
+	h := w.Header()
+	requestedHeaders := splitList(r.Header.Get("Access-Control-Request-Headers"))
+	allowed := p.OriginAllowed(origin) && contains(p.Methods, requestedMethod)
+	for _, h := range requestedHeaders {
+		allowed = allowed && contains(p.Headers, h)
+	}
+	if !allowed {
+		h.Set("Vary", preflightVary)
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	p.allowOrigin(h, origin)
+	h.Set("Vary", preflightVary)
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.Methods, ", "))
+	if len(requestedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
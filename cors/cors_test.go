@@ -0,0 +1,82 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func init() {
+	Allow("/api/", Policy{Origins: []string{"https://*.example.com"}, Methods: []string{"GET", "PUT"},
+		Headers: []string{"Content-Type"}, ExposedHeaders: []string{"X-Total"}, Credentials: true, MaxAge: time.Minute})
+	Allow("/api/public/", Policy{Origins: []string{"*"}})
+}
+
+func request(method, path, origin string) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestPreflight(t *testing.T) {
+	_t = t
+	r := request("OPTIONS", "/api/items", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	w := httptest.NewRecorder()
+	assert(Filter(w, r), "Preflight should be handled by filter.")
+	h := w.Result().Header
+	assert(w.Code == http.StatusNoContent, "Allowed preflight should get 204.")
+	assert(h.Get("Access-Control-Allow-Origin") == "https://app.example.com" && h.Get("Access-Control-Allow-Credentials") == "true", "Origin should be echoed with credentials.")
+	assert(h.Get("Access-Control-Allow-Methods") == "GET, PUT" && h.Get("Access-Control-Max-Age") == "60", "Methods and max age should be sent.")
+
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w = httptest.NewRecorder()
+	assert(Filter(w, r) && w.Code == http.StatusForbidden, "Preflight for other method should be rejected.")
+
+	r = request("OPTIONS", "/api/items", "https://example.com.evil.net")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w = httptest.NewRecorder()
+	assert(Filter(w, r) && w.Code == http.StatusForbidden, "Preflight from other origin should be rejected.")
+}
+
+func TestActualRequest(t *testing.T) {
+	_t = t
+	w := httptest.NewRecorder()
+	assert(!Filter(w, request("GET", "/api/items", "https://app.example.com")), "Actual request should be routed.")
+	assert(w.Header().Get("Access-Control-Expose-Headers") == "X-Total", "Exposed headers should be sent.")
+
+	w = httptest.NewRecorder()
+	Filter(w, request("GET", "/api/public/items", "https://other.org"))
+	assert(w.Header().Get("Access-Control-Allow-Origin") == "*", "Longest prefix policy should be used.")
+
+	w = httptest.NewRecorder()
+	Filter(w, request("GET", "/items", "https://app.example.com"))
+	assert(len(w.Header()) == 0, "Requests outside policies should not get CORS headers.")
+	for _, origin := range []string{"", "https://evil.net"} {
+		w = httptest.NewRecorder()
+		Filter(w, request("GET", "/api/items", origin))
+		assert(w.Header().Get("Access-Control-Allow-Origin") == "" && w.Header().Get("Vary") == "Origin", "Response to other origins should only vary by origin: "+origin)
+	}
+}
+
+func TestCredentialsFromAnyOrigin(t *testing.T) {
+	_t = t
+	defer func() {
+		assert(recover() != nil, "Credentials from any origin should not be allowed.")
+	}()
+	Allow("/private/", Policy{Origins: []string{"*"}, Credentials: true})
+}
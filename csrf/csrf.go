@@ -0,0 +1,225 @@
+// Package csrf protects state changing requests (POST, PUT, DELETE, PATCH)
+// against cross-site request forgery. Token is kept in session data when
+// there is stored session, otherwise in a cookie (double-submit), and has to
+// be sent back in a form field or request header.
+//
+// Install should be called before templates are loaded, e.g. with
+// upendo.AddSetupFunc, so templates can use csrfField and csrfToken functions:
+//
+//	<form method="post">{{csrfField .}}...</form>
+//	<meta name="csrf-token" content="{{csrfToken .}}">
+package csrf
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/pages"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// session data key holding the token
+	sessionKey = "__csrf"
+	// route option set by Exempt
+	exemptOption = "csrf.exempt"
+	// route option set by Deferred
+	deferredOption = "csrf.deferred"
+	// how much of multipart body may precede token of deferred route
+	maxLeadingSize = 64 << 10
+)
+
+var (
+	// methods which must not change state, they are never checked
+	safeMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true, "TRACE": true}
+)
+
+// Install registers pre route function which rejects state changing requests
+// without valid token and template functions producing tokens. If requests can
+// be authenticated with tokens, auth.Install has to be called first.
+func Install() {
+	pages.RegisterFunction("csrfField", Field)
+	pages.RegisterFunction("csrfToken", Token)
+	router.AddPreRouteFunc(Check)
+}
+
+// Exempt disables token validation for route added with router.Add, e.g. for
+// webhooks authenticated otherwise.
+func Exempt(method, path string) {
+	router.SetRouteOption(method, path, exemptOption, true)
+}
+
+// Deferred makes token of multipart requests to route added with router.Add
+// to be read from leading form fields, which have to precede files, instead of
+// parsing the whole body before the handler. Rest of the body is left to the
+// handler to stream it (see upload.Receive). Requests without valid token
+// among leading fields are rejected. Token sent in request header is checked
+// as usual.
+func Deferred(method, path string) {
+	router.SetRouteOption(method, path, deferredOption, true)
+}
+
+// Token returns CSRF token of current request, creating one if needed. New
+// token is stored in session if there is stored one, otherwise it's sent in a
+// cookie.
+func Token(c map[string]interface{}) string {
+	if t, ok := c["csrfToken"].(string); ok {
+		return t
+	}
+
+	var token string
+	s, _ := c["session"].(*session.Session)
+	if s != nil && s.ID != "" {
+		if err := s.Get(sessionKey, &token); err != nil {
+			token = security.GenerateToken(security.DefaultTokenSize)
+			controller.PanicIfNeeded(s.Set(sessionKey, token))
+		}
+	} else if token = cookieToken(c["request"].(*http.Request)); token == "" {
+		token = security.GenerateToken(security.DefaultTokenSize)
+		http.SetCookie(c["__writer"].(http.ResponseWriter), &http.Cookie{
+			Name:     settings.CSRFCookieName,
+			Value:    token,
+			Path:     "/",
+			Secure:   settings.SessionCookieSecure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	c["csrfToken"] = token
+	return token
+}
+
+// Field returns hidden form field holding CSRF token.
+func Field(c map[string]interface{}) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(settings.CSRFField), template.HTMLEscapeString(Token(c))))
+}
+
+func cookieToken(r *http.Request) string {
+	cookie, err := r.Cookie(settings.CSRFCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// submittedToken returns token sent in request header or form field.
+func submittedToken(r *http.Request) string {
+	if t := r.Header.Get(settings.CSRFHeader); t != "" {
+		return t
+	}
+	return r.PostFormValue(settings.CSRFField)
+}
+
+// Valid reports if request carries token matching token kept in session or in
+// cookie.
+func Valid(c map[string]interface{}) bool {
+	return ValidToken(c, submittedToken(c["request"].(*http.Request)))
+}
+
+// ValidToken reports if submitted token matches token kept in session or in
+// cookie.
+func ValidToken(c map[string]interface{}, submitted string) bool {
+	r := c["request"].(*http.Request)
+	if submitted == "" {
+		return false
+	}
+
+	expected := make([]string, 0, 2)
+	if s, _ := c["session"].(*session.Session); s != nil {
+		var token string
+		if s.Get(sessionKey, &token) == nil {
+			expected = append(expected, token)
+		}
+	}
+	// session may be stored after the form was rendered with cookie token
+	expected = append(expected, cookieToken(r))
+
+	for _, e := range expected {
+		if e != "" && subtle.ConstantTimeCompare([]byte(e), []byte(submitted)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenAuthenticated reports if request was authenticated with API key or
+// bearer token by auth.AuthenticateToken, browsers don't attach such
+// credentials to cross-site requests on their own. Header alone isn't enough,
+// it could be added to request authenticated with session cookie.
+func tokenAuthenticated(c map[string]interface{}) bool {
+	s, _ := c["session"].(*session.Session)
+	return s.Transient()
+}
+
+// Check is pre route function which routes state changing requests without
+// valid token to ErrorsRouting[403]. It has to be added after
+// auth.AuthenticateToken, so requests authenticated with tokens aren't
+// checked.
+func Check(cv reflect.Value) {
+	c := controller.CMap(cv)
+	r := c["request"].(*http.Request)
+	if safeMethods[r.Method] || router.ErrorPage(c) || router.RouteOption(c, exemptOption) != nil || tokenAuthenticated(c) {
+		return
+	}
+	controller.CheckSession(cv)
+	var valid bool
+	if router.RouteOption(c, deferredOption) != nil && r.Header.Get(settings.CSRFHeader) == "" &&
+		strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/") {
+		valid = ValidToken(c, leadingToken(r))
+	} else {
+		valid = Valid(c)
+	}
+	if !valid {
+		fmt.Println("CSRF token missing or invalid:", r.Method, r.URL.Path)
+		router.RouteToError(c, http.StatusForbidden)
+	}
+}
+
+// leadingToken returns token sent in form fields preceding files of multipart
+// request. Read part of the body is put back, so the handler gets it whole.
+func leadingToken(r *http.Request) string {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return ""
+	}
+	read := new(bytes.Buffer)
+	body := r.Body
+	defer func() {
+		r.Body = readCloser{io.MultiReader(read, body), body}
+	}()
+
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxLeadingSize), read), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil || part.FileName() != "" {
+			return ""
+		}
+		if part.FormName() == settings.CSRFField {
+			token, err := ioutil.ReadAll(part)
+			if err != nil {
+				return ""
+			}
+			return string(token)
+		}
+	}
+}
+
+// readCloser reads replayed body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
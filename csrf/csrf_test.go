@@ -0,0 +1,172 @@
+package csrf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func init() {
+	settings.CSRFCookieName = "csrf"
+	settings.CSRFHeader = "X-CSRF-Token"
+	settings.CSRFField = "csrf_token"
+	settings.RoutingChainMax = 4
+	router.Add("GET", "/error/:code", testController{}, "Error")
+	settings.SessionMode, settings.SessionCookieKeys = session.SessionModeCookie, "test cookie key 0123456789"
+	settings.UserProvider, settings.RestoreSessions, settings.SessionCookieName = session.UserProviderMemory, false, "session"
+	session.Initialize()
+	users := session.NewMemoryUserProvider()
+	users.AddUser(1, "alice")
+	session.GetManager().SetUserProvider(users)
+	router.AddPreRouteFunc(Check)
+}
+
+func TestCookieToken(t *testing.T) {
+	_t = t
+	w := httptest.NewRecorder()
+	c := map[string]interface{}{"request": httptest.NewRequest("GET", "/", nil), "__writer": w}
+	token := Token(c)
+	assert(token != "" && Token(c) == token, "Token should be created once per request.")
+	assert(strings.Contains(string(Field(c)), `value="`+token+`"`), "Field should hold the token.")
+	cookie := w.Result().Cookies()[0]
+	assert(cookie.Name == "csrf" && cookie.Value == token, "Token should be sent in cookie.")
+
+	form := url.Values{"csrf_token": {token}}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	assert(Valid(map[string]interface{}{"request": r}), "Token from form should be accepted.")
+
+	r = httptest.NewRequest("POST", "/", nil)
+	r.AddCookie(cookie)
+	assert(!Valid(map[string]interface{}{"request": r}), "Request without token should be rejected.")
+	r.Header.Set("X-CSRF-Token", token[1:])
+	assert(!Valid(map[string]interface{}{"request": r}), "Wrong token should be rejected.")
+}
+
+func TestSessionToken(t *testing.T) {
+	_t = t
+	s := &session.Session{ID: "abc", UserName: "alice"}
+	c := map[string]interface{}{"request": httptest.NewRequest("GET", "/", nil), "session": s}
+	token := Token(c)
+	assert(s.Has(sessionKey) && s.Dirty(), "Token should be stored in session.")
+
+	r := httptest.NewRequest("DELETE", "/", nil)
+	r.Header.Set("X-CSRF-Token", token)
+	assert(Valid(map[string]interface{}{"request": r, "session": s}), "Token from header should be accepted.")
+	assert(!Valid(map[string]interface{}{"request": r, "session": &session.Session{ID: "def"}}), "Token of other session should be rejected.")
+}
+
+type testController map[string]interface{}
+
+func (c testController) Comment() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "saved")
+}
+
+func (c testController) Upload() {
+	r := c["request"].(*http.Request)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		panic(err)
+	}
+	for part, err := mr.NextPart(); err == nil; part, err = mr.NextPart() {
+		content, _ := ioutil.ReadAll(part)
+		fmt.Fprint(c["writer"].(*bytes.Buffer), part.FormName(), "=", string(content), ";")
+	}
+}
+
+func (c testController) Error() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "error ", c["code"])
+}
+
+func TestCheck(t *testing.T) {
+	_t = t
+	router.Add("POST", "/comments", testController{}, "Comment")
+
+	w := httptest.NewRecorder()
+	router.RouteRequest(w, httptest.NewRequest("POST", "/comments", nil))
+	assert(w.Code == http.StatusForbidden && w.Body.String() == "error 403", "Request without token should be routed to error page.")
+
+	r := httptest.NewRequest("POST", "/comments", nil)
+	r.AddCookie(&http.Cookie{Name: "csrf", Value: "token-1"})
+	r.Header.Set("X-CSRF-Token", "token-1")
+	w = httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	assert(w.Code == http.StatusOK && w.Body.String() == "saved", "Request with token should be handled.")
+
+	w = httptest.NewRecorder()
+	_, err := session.GetManager().CreateSession(map[string]interface{}{"login": "alice", "request": r, "__writer": w})
+	assert(err == nil, "Session should be created.")
+	r = httptest.NewRequest("POST", "/comments", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	r.Header.Set("Authorization", "Bearer junk")
+	w = httptest.NewRecorder()
+	router.RouteRequest(w, r)
+	assert(w.Code == http.StatusForbidden, "Request with session cookie and invalid bearer token should be checked.")
+	transient, _ := session.GetManager().TransientSession("alice", r)
+	assert(tokenAuthenticated(map[string]interface{}{"session": transient}), "Request authenticated with token should not be checked.")
+}
+
+// upload builds multipart request of "name=value" fields and "field:file=content"
+// files.
+func upload(parts ...string) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		if field := strings.SplitN(kv[0], ":", 2); len(field) == 2 {
+			w, _ := mw.CreateFormFile(field[0], field[1])
+			w.Write([]byte(kv[1]))
+		} else {
+			mw.WriteField(kv[0], kv[1])
+		}
+	}
+	mw.Close()
+	r := httptest.NewRequest("POST", "/uploads", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: "csrf", Value: "token-1"})
+	return r
+}
+
+func TestDeferred(t *testing.T) {
+	_t = t
+	router.Add("POST", "/uploads", testController{}, "Upload")
+	Deferred("POST", "/uploads")
+
+	file := strings.Repeat("data ", 20000)
+	w := httptest.NewRecorder()
+	router.RouteRequest(w, upload("title=x", "csrf_token=token-1", "a:a.txt="+file))
+	assert(w.Code == http.StatusOK && w.Body.String() == "title=x;csrf_token=token-1;a="+file+";", "Handler should get whole body of upload with valid token.")
+
+	rejected := [][]string{
+		{"csrf_token=forged", "a:a.txt=x"},
+		{"a:a.txt=x", "csrf_token=token-1"},
+		{"title=x"},
+		{"title=" + strings.Repeat("x", maxLeadingSize), "csrf_token=token-1"},
+	}
+	for _, parts := range rejected {
+		w = httptest.NewRecorder()
+		router.RouteRequest(w, upload(parts...))
+		assert(w.Code == http.StatusForbidden && w.Body.String() == "error 403", "Upload without valid leading token should be rejected.")
+	}
+}
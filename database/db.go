@@ -2,22 +2,36 @@ package database
 
 import (
 	"database/sql"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
 	databases map[string]*sql.DB = make(map[string]*sql.DB)
+	mutex     sync.Mutex
 )
 
 func ConnectToDb(user, pass, dbname string) (*sql.DB, error) {
-	key := user + ":" + pass + "@/" + dbname
+	return Open("mysql", user+":"+pass+"@/"+dbname)
+}
+
+// Open returns database handle for given driver and data source name. Handles
+// are shared, so opening the same database many times is cheap.
+func Open(driver, dsn string) (*sql.DB, error) {
+	key := driver + ":" + dsn
+
+	mutex.Lock()
+	defer mutex.Unlock()
 
 	db, ok := databases[key]
 	if ok {
 		return db, nil
 	}
 
-	db, err := sql.Open("mysql", key)
+	db, err := sql.Open(driver, dsn)
+	if err == nil {
+		databases[key] = db
+	}
 	return db, err
 }
@@ -0,0 +1,157 @@
+// Package headers adds security headers to responses: Content-Security-Policy
+// with per request nonce, X-Content-Type-Options, X-Frame-Options,
+// Referrer-Policy, Permissions-Policy and Strict-Transport-Security (HTTPS
+// only). Values come from settings and can be overridden per route.
+//
+// Install has to be called after settings are initialized and before templates
+// are loaded, e.g. with upendo.AddSetupFunc. Templates can use the nonce:
+//
+//	<script nonce="{{cspNonce .}}">...</script>
+package headers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/csrf"
+	"github.com/solgar/upendo/pages"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	cspHeader = "Content-Security-Policy"
+	// route option set by Override
+	overridesOption = "headers.overrides"
+	// size of nonce in bytes
+	nonceSize = 16
+	// CSP reports are logged truncated to that many bytes
+	maxReportSize = 512
+)
+
+var (
+	overrides = make(map[string]map[string]string)
+)
+
+// Controller collects CSP violation reports.
+type Controller router.Controller
+
+// Install registers pre route function adding headers, cspNonce template
+// function and CSP report endpoint if -csp-report-uri is set.
+func Install() {
+	pages.RegisterFunction("cspNonce", Nonce)
+	router.AddPreRouteFunc(SetHeaders)
+	if settings.CSPReportURI != "" {
+		router.Add("POST", settings.CSPReportURI, Controller{}, "Report")
+		// browsers send reports without CSRF token
+		csrf.Exempt("POST", settings.CSPReportURI)
+	}
+}
+
+// Override sets header value for route added with router.Add, empty value
+// removes the header. Content-Security-Policy override can use {nonce} too.
+func Override(method, path, header, value string) {
+	key := strings.ToUpper(method) + " " + path
+	o, ok := overrides[key]
+	if !ok {
+		o = make(map[string]string)
+		overrides[key] = o
+		router.SetRouteOption(method, path, overridesOption, o)
+	}
+	o[http.CanonicalHeaderKey(header)] = value
+}
+
+// Nonce returns CSP nonce of current request, it's empty if CSP is disabled.
+func Nonce(c map[string]interface{}) string {
+	nonce, _ := c["cspNonce"].(string)
+	return nonce
+}
+
+// SetHeaders is pre route function which adds security headers to response.
+// Handlers can still change them with controller.AddHeader.
+func SetHeaders(cv reflect.Value) {
+	c := controller.CMap(cv)
+	r := c["request"].(*http.Request)
+	headers := c["headers"].(map[string]string)
+	routeOverrides, _ := router.RouteOption(c, overridesOption).(map[string]string)
+
+	set := func(name, value string) {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	set("X-Content-Type-Options", "nosniff")
+	set("X-Frame-Options", settings.FrameOptions)
+	set("Referrer-Policy", settings.ReferrerPolicy)
+	set("Permissions-Policy", settings.PermissionsPolicy)
+	if r.TLS != nil && settings.HSTSMaxAge > 0 {
+		set("Strict-Transport-Security", "max-age="+strconv.FormatInt(int64(settings.HSTSMaxAge/time.Second), 10)+"; includeSubDomains")
+	}
+
+	policy := settings.ContentSecurityPolicy
+	for name, value := range routeOverrides {
+		if name == cspHeader {
+			policy = value
+		} else if value == "" {
+			delete(headers, name)
+		} else {
+			headers[name] = value
+		}
+	}
+	if policy == "" {
+		return
+	}
+
+	nonce := security.GenerateToken(nonceSize)
+	c["cspNonce"] = nonce
+	policy = strings.Replace(policy, "{nonce}", "'nonce-"+nonce+"'", -1)
+	if settings.CSPReportURI != "" {
+		policy += "; report-uri " + settings.CSPReportURI
+	}
+	if settings.CSPReportOnly {
+		headers[cspHeader+"-Report-Only"] = policy
+	} else {
+		headers[cspHeader] = policy
+	}
+}
+
+// Report logs CSP violation report sent by browser in single line, truncated
+// to maxReportSize bytes.
+func (c Controller) Report() {
+	r := c["request"].(*http.Request)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReportSize+1))
+	if err != nil {
+		fmt.Println("Cannot read CSP report:", err)
+	} else {
+		fmt.Println("CSP violation:", reportLine(body))
+	}
+	c["StatusCode"] = http.StatusNoContent
+}
+
+// reportLine joins report into single line without control characters.
+func reportLine(body []byte) string {
+	truncated := len(body) > maxReportSize
+	if truncated {
+		body = body[:maxReportSize]
+	}
+	line := strings.Join(strings.Fields(strings.ToValidUTF8(string(body), "")), " ")
+	line = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+	if truncated {
+		line += "..."
+	}
+	return line
+}
@@ -0,0 +1,120 @@
+package headers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/csrf"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func setDefaults() {
+	settings.ContentSecurityPolicy = "script-src 'self' {nonce}"
+	settings.CSPReportOnly = false
+	settings.CSPReportURI = "/csp-report"
+	settings.FrameOptions = "SAMEORIGIN"
+	settings.ReferrerPolicy = "no-referrer"
+	settings.PermissionsPolicy = ""
+	settings.HSTSMaxAge = time.Hour
+}
+
+func run(c map[string]interface{}) map[string]string {
+	SetHeaders(reflect.ValueOf(c))
+	return c["headers"].(map[string]string)
+}
+
+func TestSetHeaders(t *testing.T) {
+	_t = t
+	setDefaults()
+	c := map[string]interface{}{"request": httptest.NewRequest("GET", "/", nil), "headers": map[string]string{}}
+	h := run(c)
+	nonce := Nonce(c)
+	assert(nonce != "", "Nonce should be generated.")
+	assert(h["Content-Security-Policy"] == "script-src 'self' 'nonce-"+nonce+"'; report-uri /csp-report", "Nonce should be put in policy.")
+	assert(h["X-Content-Type-Options"] == "nosniff" && h["X-Frame-Options"] == "SAMEORIGIN" && h["Referrer-Policy"] == "no-referrer", "Configured headers should be set.")
+	_, ok := h["Permissions-Policy"]
+	assert(!ok, "Empty setting should disable header.")
+	_, ok = h["Strict-Transport-Security"]
+	assert(!ok, "HSTS should not be sent over HTTP.")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	settings.CSPReportOnly = true
+	c = map[string]interface{}{"request": r, "headers": map[string]string{}}
+	h = run(c)
+	assert(h["Strict-Transport-Security"] == "max-age=3600; includeSubDomains", "HSTS should be sent over HTTPS.")
+	assert(strings.HasPrefix(h["Content-Security-Policy-Report-Only"], "script-src"), "Report only header should be used.")
+	assert(Nonce(c) != nonce, "Nonce should be unique per request.")
+}
+
+func TestRouteOverrides(t *testing.T) {
+	_t = t
+	setDefaults()
+	overrides := map[string]string{"Content-Security-Policy": "frame-ancestors *", "X-Frame-Options": ""}
+	c := map[string]interface{}{"request": httptest.NewRequest("GET", "/embed", nil), "headers": map[string]string{},
+		"__routeOptions": map[string]interface{}{overridesOption: overrides}}
+	h := run(c)
+	_, ok := h["X-Frame-Options"]
+	assert(!ok, "Empty override should remove header.")
+	assert(h["Content-Security-Policy"] == "frame-ancestors *; report-uri /csp-report", "Policy should be overridden.")
+}
+
+func TestReportLine(t *testing.T) {
+	_t = t
+	assert(reportLine([]byte("{\n  \"csp-report\": {}\r\n}\x1b[2J")) == `{ "csp-report": {} }[2J`, "Report should be logged in single line.")
+	line := reportLine([]byte(strings.Repeat("a", maxReportSize+1)))
+	assert(len(line) == maxReportSize+3 && strings.HasSuffix(line, "..."), "Long report should be truncated.")
+}
+
+type testController map[string]interface{}
+
+func (c testController) Comment() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "saved")
+}
+
+func (c testController) Error() {
+	_, ok := c["session"].(*session.Session)
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "error ", c["code"], " session ", ok, " nonce ", Nonce(c))
+}
+
+func TestErrorPage(t *testing.T) {
+	_t = t
+	setDefaults()
+	settings.SessionMode, settings.SessionCookieKeys = session.SessionModeCookie, "test cookie key 0123456789"
+	settings.UserProvider, settings.RestoreSessions, settings.RoutingChainMax = session.UserProviderMemory, false, 4
+	settings.CSRFCookieName, settings.CSRFField = "csrf", "csrf_token"
+	session.Initialize()
+	router.Add("POST", "/comments", testController{}, "Comment")
+	router.Add("GET", "/error/:code", testController{}, "Error")
+	router.AddPreRouteFunc(controller.CheckSession)
+	router.AddPreRouteFunc(SetHeaders)
+	router.AddPreRouteFunc(csrf.Check)
+
+	w := httptest.NewRecorder()
+	router.RouteRequest(w, httptest.NewRequest("POST", "/comments", nil))
+	body := w.Body.String()
+	assert(w.Code == http.StatusForbidden && strings.HasPrefix(body, "error 403 session true nonce "), "Error page should get session: "+body)
+	nonce := strings.TrimPrefix(body, "error 403 session true nonce ")
+	assert(nonce != "" && strings.Contains(w.Header().Get("Content-Security-Policy"), "'nonce-"+nonce+"'"), "Nonce of error page should match its policy.")
+	assert(w.Header().Get("X-Content-Type-Options") == "nosniff", "Error page should get security headers.")
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package listener
+
+import "syscall"
+
+// closeOnExec keeps inherited descriptor from leaking to child processes.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
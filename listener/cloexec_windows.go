@@ -0,0 +1,5 @@
+package listener
+
+// closeOnExec does nothing, descriptors aren't inherited on Windows.
+func closeOnExec(fd int) {
+}
@@ -0,0 +1,302 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+	listenFdsStart = 3
+
+	unixPrefix    = "unix:"
+	fdPrefix      = "fd:"
+	systemdSpec   = "systemd"
+	nameSeparator = "="
+
+	// set by Handoff, used instead of LISTEN_PID which cannot be known
+	// before child process is started
+	handoffParentEnv = "UPENDO_LISTEN_PPID"
+)
+
+type contextKey struct{}
+
+// Listener is a net.Listener with a name which is used to recognize on which
+// listener request has arrived (see Name function).
+type Listener struct {
+	net.Listener
+	Name string
+	Spec string
+}
+
+// Parse splits comma separated list of listen specifications. Each
+// specification can be optionally prefixed with a name followed by "=", e.g.
+// "admin=127.0.0.1:9000". Unnamed specifications are named after themselves.
+func Parse(specs string) []string {
+	result := make([]string, 0)
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec != "" {
+			result = append(result, spec)
+		}
+	}
+	return result
+}
+
+// Open creates listeners for given specifications. Supported forms are:
+//
+//	":8080", "host:port"    - TCP listener
+//	"unix:/path/to.sock"    - Unix domain socket, stale socket file is removed
+//	"fd:3"                  - inherited file descriptor with given number
+//	"fd:name"               - inherited descriptor named in LISTEN_FDNAMES
+//	"systemd"               - all descriptors passed using LISTEN_FDS
+//
+// TCP and Unix socket listeners passed by Handoff are reused instead of being
+// opened again. If opening any of listeners fails all already opened are
+// closed.
+func Open(specs []string) ([]*Listener, error) {
+	inherited := inheritedFiles()
+	inheritedUsed := make([]bool, len(inherited))
+
+	listeners := make([]*Listener, 0, len(specs))
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, s := range specs {
+		name, spec := splitName(s)
+		var opened []*Listener
+		var err error
+
+		switch {
+		case spec == systemdSpec:
+			opened, err = fromFiles(name, inherited, inheritedUsed, func(int, *os.File) bool { return true })
+			if err == nil && len(opened) == 0 {
+				err = errors.New("Error while opening listener: no file descriptors passed by systemd (LISTEN_FDS).")
+			}
+		case strings.HasPrefix(spec, fdPrefix):
+			opened, err = fromFiles(name, inherited, inheritedUsed, fdMatcher(spec[len(fdPrefix):]))
+			if err == nil && len(opened) == 0 {
+				err = errors.New("Error while opening listener: no inherited file descriptor for \"" + spec + "\".")
+			}
+		default:
+			opened, err = fromFiles(name, inherited, inheritedUsed, func(i int, f *os.File) bool { return f.Name() == spec })
+			if err != nil || len(opened) > 0 {
+				break
+			}
+			var l net.Listener
+			if strings.HasPrefix(spec, unixPrefix) {
+				l, err = listenUnix(spec[len(unixPrefix):])
+			} else {
+				l, err = net.Listen("tcp", spec)
+			}
+			if err == nil {
+				opened = []*Listener{{l, name, spec}}
+			}
+		}
+
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, opened...)
+	}
+
+	return listeners, nil
+}
+
+// Name returns name of listener on which request has arrived. Empty string is
+// returned if request was not received through listener created by this
+// package.
+func Name(r *http.Request) string {
+	name, _ := r.Context().Value(contextKey{}).(string)
+	return name
+}
+
+// BaseContext returns function suitable for http.Server.BaseContext which
+// makes listener name available through Name function.
+func BaseContext(l *Listener) func(net.Listener) context.Context {
+	return func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), contextKey{}, l.Name)
+	}
+}
+
+// splitName returns name and specification. Text before "=" is a name only
+// if it's made of letters, digits, "_" and "-", so socket paths containing "="
+// aren't split.
+func splitName(spec string) (string, string) {
+	idx := strings.Index(spec, nameSeparator)
+	if idx <= 0 || strings.IndexFunc(spec[:idx], notNameRune) != -1 {
+		return spec, spec
+	}
+	return spec[:idx], spec[idx+1:]
+}
+
+func notNameRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+}
+
+func fdMatcher(fd string) func(int, *os.File) bool {
+	n, err := strconv.Atoi(fd)
+	if err == nil {
+		return func(i int, f *os.File) bool { return listenFdsStart+i == n }
+	}
+	return func(i int, f *os.File) bool { return f.Name() == fd }
+}
+
+func fromFiles(name string, files []*os.File, used []bool, match func(int, *os.File) bool) ([]*Listener, error) {
+	result := make([]*Listener, 0)
+	for i, f := range files {
+		if used[i] || !match(i, f) {
+			continue
+		}
+		l, err := net.FileListener(f)
+		if err != nil {
+			for _, opened := range result {
+				opened.Close()
+			}
+			return nil, errors.New("Error while opening listener from file descriptor " + f.Name() + ": " + err.Error())
+		}
+		used[i] = true
+		// net.FileListener dups descriptor, original is not needed anymore
+		f.Close()
+		result = append(result, &Listener{l, name, f.Name()})
+	}
+	return result, nil
+}
+
+// Handoff starts new instance of running executable with the same arguments
+// and passes given listeners to it, so it can start accepting connections
+// before current process stops. Listeners are passed using systemd socket
+// activation protocol and are named after their specification, so that new
+// instance picks them up in Open instead of binding addresses again.
+func Handoff(listeners []*Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, len(listeners))
+	names := make([]string, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, l := range listeners {
+		filer, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, errors.New("Error while handing off listener " + l.Spec + ": cannot get file descriptor.")
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, err
+		}
+		// socket file is used by new instance now
+		if ul, ok := l.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		files = append(files, f)
+		names = append(names, url.QueryEscape(l.Spec))
+	}
+
+	env := make([]string, 0)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "LISTEN_") && !strings.HasPrefix(e, handoffParentEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		handoffParentEnv+"="+strconv.Itoa(os.Getpid()))
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// inheritedFiles returns files passed using systemd socket activation protocol
+// (LISTEN_PID, LISTEN_FDS and optional LISTEN_FDNAMES) or by Handoff.
+// Environment variables are cleared so that child processes won't inherit
+// them.
+func inheritedFiles() []*os.File {
+	if os.Getenv(handoffParentEnv) != "" {
+		ppid, err := strconv.Atoi(os.Getenv(handoffParentEnv))
+		if err != nil || ppid != os.Getppid() {
+			return nil
+		}
+	} else {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return nil
+		}
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	os.Unsetenv(handoffParentEnv)
+
+	files := make([]*os.File, count)
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		closeOnExec(fd)
+		name := "fd:" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+		}
+		files[i] = os.NewFile(uintptr(fd), name)
+	}
+	return files
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("Error while opening listener: empty unix socket path.")
+	}
+
+	// remove stale socket left by previous instance, but never regular files
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("Error while opening listener: " + path + " exists and is not a socket.")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(true)
+	return l, nil
+}
@@ -0,0 +1,79 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T
+)
+
+func TestParse(t *testing.T) {
+	_t = t
+	specs := Parse(" :8080, admin=127.0.0.1:9000 ,,unix:/tmp/a.sock")
+	assert(len(specs) == 3, "Three specifications expected.")
+	assert(specs[0] == ":8080", "Spaces should be trimmed.")
+
+	name, spec := splitName(specs[1])
+	assert(name == "admin" && spec == "127.0.0.1:9000", "Wrong name or spec.")
+
+	name, spec = splitName(specs[2])
+	assert(name == spec && spec == "unix:/tmp/a.sock", "Unnamed spec should be named after itself.")
+
+	name, spec = splitName("unix:/run/app=1.sock")
+	assert(name == spec && spec == "unix:/run/app=1.sock", "Socket path with \"=\" should not be split.")
+	name, spec = splitName("local=unix:/run/app=1.sock")
+	assert(name == "local" && spec == "unix:/run/app=1.sock", "Named socket path with \"=\" should be kept whole.")
+}
+
+func TestOpenTCPAndUnix(t *testing.T) {
+	_t = t
+	socketPath := filepath.Join(t.TempDir(), "upendo.sock")
+
+	listeners, err := Open([]string{"local=127.0.0.1:0", "unix:" + socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(len(listeners) == 2, "Two listeners expected.")
+	assert(listeners[0].Name == "local", "Wrong listener name.")
+	assert(listeners[1].Name == "unix:"+socketPath, "Wrong listener name.")
+
+	conn, err := net.Dial("unix", socketPath)
+	assert(err == nil, "Should connect to unix socket.")
+	if conn != nil {
+		conn.Close()
+	}
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	_, err = os.Stat(socketPath)
+	assert(os.IsNotExist(err), "Socket file should be removed on close.")
+}
+
+func TestOpenRejectsRegularFile(t *testing.T) {
+	_t = t
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Open([]string{"unix:" + path})
+	assert(err != nil, "Regular file should not be removed.")
+}
+
+func TestOpenMissingDescriptor(t *testing.T) {
+	_t = t
+	_, err := Open([]string{"fd:3"})
+	assert(err != nil, "Error expected when no descriptors are inherited.")
+}
@@ -0,0 +1,168 @@
+// Package oidc lets users log in with external OpenID Connect provider using
+// authorization code flow with PKCE. State, nonce and code verifier are kept
+// in session until the provider redirects user back, identity from verified
+// ID token is then logged in with session manager like a password login.
+//
+// Provider configured with -oidc-* settings is installed with:
+//
+//	upendo.AddSetupFunc(oidc.Install)
+package oidc
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/solgar/upendo/auth"
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// session data key holding state of pending login
+	flowKey = "__oidc"
+)
+
+var (
+	provider *Provider
+)
+
+// flow is state of login started by the user, kept in session.
+type flow struct {
+	State     string
+	Nonce     string
+	Verifier  string
+	ReturnURL string
+}
+
+// Controller starts login and handles provider's callback.
+type Controller router.Controller
+
+// Install discovers provider configured with -oidc-* settings and registers
+// its routes. It does nothing if -oidc-issuer is empty.
+func Install() {
+	if settings.OIDCIssuer == "" {
+		return
+	}
+	p := &Provider{
+		Issuer:       settings.OIDCIssuer,
+		ClientID:     settings.OIDCClientID,
+		ClientSecret: settings.OIDCClientSecret,
+		RedirectURL:  settings.OIDCRedirectURL,
+		Scopes:       strings.Fields(settings.OIDCScopes),
+		LoginClaim:   settings.OIDCLoginClaim,
+	}
+	if err := p.Discover(); err != nil {
+		panic(err)
+	}
+	Use(p)
+}
+
+// Use registers routes of given provider: login is started at -oidc-login-url
+// and provider redirects back to path of p.RedirectURL.
+func Use(p *Provider) {
+	callback, err := url.Parse(p.RedirectURL)
+	if err != nil || !callback.IsAbs() {
+		panic("OpenID Connect redirect URL has to be absolute: " + p.RedirectURL)
+	}
+	provider = p
+	router.Add("GET", settings.OIDCLoginURL, Controller{}, "Start")
+	router.Add("GET", callback.Path, Controller{}, "Callback")
+}
+
+// Start sends user to provider's authorization page. Optional "return"
+// parameter is where user is sent after logging in.
+func (c Controller) Start() {
+	r := c["request"].(*http.Request)
+	f := flow{
+		State:     security.GenerateToken(security.DefaultTokenSize),
+		Nonce:     security.GenerateToken(security.DefaultTokenSize),
+		Verifier:  security.GenerateToken(security.DefaultTokenSize),
+		ReturnURL: auth.SafeReturnURL(r.URL.Query().Get("return")),
+	}
+	s := controller.Session(c)
+	if s == nil {
+		panic("Session manager not initialized.")
+	}
+	controller.PanicIfNeeded(s.Set(flowKey, f))
+	router.Redirect(c, provider.AuthCodeURL(f.State, f.Nonce, f.Verifier))
+}
+
+// Callback finishes login started with Start. Requests not matching pending
+// login or rejected by the provider are routed to ErrorsRouting[401], users
+// unknown to the application to ErrorsRouting[403]. Users with second factor
+// enabled are sent to -two-factor-url, as after password login.
+func (c Controller) Callback() {
+	r := c["request"].(*http.Request)
+	q := r.URL.Query()
+	s, _ := c["session"].(*session.Session)
+
+	var f flow
+	if s == nil || s.Get(flowKey, &f) != nil {
+		fmt.Println("OpenID Connect callback without pending login.")
+		router.RouteToError(c, http.StatusUnauthorized)
+		return
+	}
+	// state can be used only once
+	s.Delete(flowKey)
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(f.State)) != 1 {
+		fmt.Println("OpenID Connect callback with invalid state.")
+		router.RouteToError(c, http.StatusUnauthorized)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fmt.Println("OpenID Connect login rejected:", e, q.Get("error_description"))
+		router.RouteToError(c, http.StatusUnauthorized)
+		return
+	}
+
+	login, err := c.identify(q.Get("code"), f)
+	if err != nil {
+		fmt.Println("OpenID Connect login failed:", err)
+		router.RouteToError(c, http.StatusUnauthorized)
+		return
+	}
+
+	smanager := session.GetManager()
+	if smanager == nil {
+		panic("Session manager not initialized.")
+	}
+	// second factor of the application is required also from users signed in
+	// by the provider
+	twoFactor, err := auth.TwoFactorEnabled(login)
+	controller.PanicIfNeeded(err)
+	if twoFactor {
+		auth.StartTwoFactor(c, false)
+	}
+	c["login"] = login
+	_, err = smanager.CreateSession(c)
+	if err == session.ErrUserNotFound {
+		fmt.Println("OpenID Connect login of unknown user:", login)
+		router.RouteToError(c, http.StatusForbidden)
+		return
+	}
+	controller.PanicIfNeeded(err)
+	if twoFactor {
+		router.Redirect(c, settings.TwoFactorURL+"?return="+url.QueryEscape(f.ReturnURL))
+		return
+	}
+	router.Redirect(c, f.ReturnURL)
+}
+
+// identify exchanges code for tokens and returns login of verified identity.
+func (c Controller) identify(code string, f flow) (string, error) {
+	tokens, err := provider.Exchange(code, f.Verifier)
+	if err != nil {
+		return "", err
+	}
+	claims, err := provider.VerifyIDToken(tokens.IDToken, f.Nonce)
+	if err != nil {
+		return "", err
+	}
+	return provider.Identity(claims)
+}
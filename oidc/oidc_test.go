@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solgar/upendo/auth"
+	"github.com/solgar/upendo/security"
+	"github.com/solgar/upendo/session"
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+// testProvider is local stand-in of OpenID Connect provider.
+type testProvider struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	kid        string
+	challenge  string
+	nonce      string
+	claims     map[string]interface{}
+	jwksserved int
+}
+
+func newTestProvider() *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	tp := &testProvider{key: key, kid: "k1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.server.URL,
+			"authorization_endpoint": tp.server.URL + "/authorize",
+			"token_endpoint":         tp.server.URL + "/token",
+			"jwks_uri":               tp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		tp.jwksserved++
+		n := base64.RawURLEncoding.EncodeToString(tp.key.N.Bytes())
+		w.Write([]byte(`{"keys":[{"kty":"RSA","use":"sig","kid":"` + tp.kid + `","n":"` + n + `","e":"AQAB"}]}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.PostFormValue("code") != "code-1" || id != "client" || secret != "secret" ||
+			codeChallenge(r.PostFormValue("code_verifier")) != tp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id_token": tp.idToken(), "token_type": "Bearer"})
+	})
+	tp.server = httptest.NewServer(mux)
+	return tp
+}
+
+func (tp *testProvider) idToken() string {
+	claims := map[string]interface{}{
+		"iss": tp.server.URL, "aud": "client", "sub": "123", "nonce": tp.nonce,
+		"email": "alice@example.com", "email_verified": true,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range tp.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": tp.kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, tp.key, crypto.SHA256, digest[:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (tp *testProvider) client() *Provider {
+	return &Provider{
+		Issuer:       tp.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/login/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+		LoginClaim:   "email",
+	}
+}
+
+func TestProviderFlow(t *testing.T) {
+	_t = t
+	tp := newTestProvider()
+	defer tp.server.Close()
+	p := tp.client()
+	assert(p.Discover() == nil && p.TokenEndpoint == tp.server.URL+"/token", "Endpoints should be discovered.")
+
+	u, _ := url.Parse(p.AuthCodeURL("state-1", "nonce-1", "verifier-1"))
+	q := u.Query()
+	assert(u.Path == "/authorize" && q.Get("client_id") == "client" && q.Get("scope") == "openid email", "Wrong authorization URL.")
+	assert(q.Get("code_challenge_method") == "S256" && q.Get("code_challenge") == codeChallenge("verifier-1"), "PKCE challenge should be sent.")
+	tp.challenge, tp.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	_, err := p.Exchange("code-1", "other-verifier")
+	assert(err != nil, "Code should not be exchanged with wrong verifier.")
+	tokens, err := p.Exchange("code-1", "verifier-1")
+	assert(err == nil, "Code should be exchanged.")
+
+	claims, err := p.VerifyIDToken(tokens.IDToken, "nonce-1")
+	assert(err == nil, "ID token should be valid.")
+	login, err := p.Identity(claims)
+	assert(err == nil && login == "alice@example.com", "Identity should be taken from login claim.")
+	_, err = p.VerifyIDToken(tokens.IDToken, "nonce-2")
+	assert(err == ErrInvalidIDToken, "ID token with other nonce should be rejected.")
+
+	tp.claims = map[string]interface{}{"aud": "other-client"}
+	_, err = p.VerifyIDToken(tp.idToken(), "nonce-1")
+	assert(err != nil, "ID token for other client should be rejected.")
+	for _, verified := range []interface{}{false, nil, "true"} {
+		tp.claims = map[string]interface{}{"email_verified": verified}
+		claims, _ = p.VerifyIDToken(tp.idToken(), "nonce-1")
+		_, err = p.Identity(claims)
+		assert(err != nil, fmt.Sprint("Email not verified by provider should not be used as login: ", verified))
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	_t = t
+	tp := newTestProvider()
+	defer tp.server.Close()
+	p := tp.client()
+	p.Discover()
+	tp.nonce = "nonce-1"
+
+	_, err := p.VerifyIDToken(tp.idToken(), "nonce-1")
+	_, err2 := p.VerifyIDToken(tp.idToken(), "nonce-1")
+	assert(err == nil && err2 == nil && tp.jwksserved == 1, "Provider keys should be cached.")
+
+	tp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	tp.kid = "k2"
+	_, err = p.VerifyIDToken(tp.idToken(), "nonce-1")
+	assert(err != nil && tp.jwksserved == 1, "Keys should not be refetched too often.")
+	p.keysFetched = p.keysFetched.Add(-2 * jwksMinRefresh)
+	_, err = p.VerifyIDToken(tp.idToken(), "nonce-1")
+	assert(err == nil && tp.jwksserved == 2, "Keys should be refetched for unknown key id.")
+}
+
+func TestCallbackState(t *testing.T) {
+	_t = t
+	settings.LoginRedirect = "/"
+	tp := newTestProvider()
+	defer tp.server.Close()
+	provider = tp.client()
+	provider.Discover()
+
+	s := &session.Session{}
+	c := Controller{"session": s, "request": httptest.NewRequest("GET", "/login/oidc?return=/account", nil)}
+	c.Start()
+	u, _ := url.Parse(c["Location"].(string))
+	var f flow
+	assert(s.Get(flowKey, &f) == nil && f.ReturnURL == "/account", "Login flow should be kept in session.")
+	assert(u.Query().Get("state") == f.State && u.Query().Get("nonce") == f.Nonce, "State and nonce should be sent to provider.")
+
+	c = Controller{"session": s, "request": httptest.NewRequest("GET", "/login/oidc/callback?code=code-1&state=forged", nil)}
+	c.Callback()
+	assert(c["__routeError"] == http.StatusUnauthorized, "Callback with forged state should be rejected.")
+	assert(!s.Has(flowKey), "Login flow should be usable only once.")
+
+	c = Controller{"session": s, "request": httptest.NewRequest("GET", "/login/oidc/callback?code=code-1&state="+f.State, nil)}
+	c.Callback()
+	assert(c["__routeError"] == http.StatusUnauthorized, "Callback without pending login should be rejected.")
+}
+
+func TestCallbackTwoFactor(t *testing.T) {
+	_t = t
+	settings.SessionMode, settings.SessionCookieKeys = session.SessionModeCookie, "test cookie key 0123456789"
+	settings.UserProvider, settings.RestoreSessions, settings.SessionCookieName = session.UserProviderMemory, false, "session"
+	settings.TwoFactorURL = "/login/2fa"
+	session.Initialize()
+	users := session.NewMemoryUserProvider()
+	users.AddUser(1, "alice@example.com")
+	session.GetManager().SetUserProvider(users)
+	auth.SetTwoFactorStore(auth.NewMemoryTwoFactorStore())
+	secret, _ := auth.NewTOTPSecret("alice@example.com")
+	code, _ := security.TOTPCode(secret, time.Now())
+	_, err := auth.EnableTwoFactor("alice@example.com", secret, code)
+	assert(err == nil, "Second factor should be enabled.")
+
+	tp := newTestProvider()
+	defer tp.server.Close()
+	provider = tp.client()
+	provider.Discover()
+	s := &session.Session{}
+	c := Controller{"session": s, "request": httptest.NewRequest("GET", "/login/oidc?return=/account", nil)}
+	c.Start()
+	u, _ := url.Parse(c["Location"].(string))
+	tp.challenge, tp.nonce = u.Query().Get("code_challenge"), u.Query().Get("nonce")
+
+	w := httptest.NewRecorder()
+	c = Controller{"session": s, "__writer": w,
+		"request": httptest.NewRequest("GET", "/login/oidc/callback?code=code-1&state="+u.Query().Get("state"), nil)}
+	c.Callback()
+	assert(strings.HasPrefix(c["Location"].(string), "/login/2fa?return="), "User should be sent to second factor page.")
+	created, _ := c["session"].(*session.Session)
+	assert(created != nil && created.UserName == "alice@example.com" && created.TwoFactorPending(), "Session should wait for second factor.")
+}
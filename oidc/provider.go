@@ -0,0 +1,262 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/security"
+)
+
+const (
+	// how long fetched provider keys are used
+	jwksCacheTTL = time.Hour
+	// keys are fetched again on unknown "kid" at most this often
+	jwksMinRefresh = time.Minute
+	// limit of provider responses
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// ErrInvalidIDToken is returned when ID token is not valid for this
+	// client or login flow.
+	ErrInvalidIDToken = errors.New("Invalid ID token.")
+)
+
+// Provider describes OpenID Connect provider and this application registered
+// as its client. Endpoints are filled by Discover.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// absolute URL of callback route registered at the provider
+	RedirectURL string
+	Scopes      []string
+	// ID token claim used as login, "sub" if empty
+	LoginClaim string
+	// MapIdentity returns login of user identified by ID token claims, it
+	// replaces LoginClaim, e.g. to provision users on first login
+	MapIdentity func(claims security.JWTClaims) (string, error)
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	// HTTP client used to talk to the provider, http.DefaultClient if nil
+	Client *http.Client
+
+	keysMutex   sync.Mutex
+	keys        []security.JWTKey
+	keysFetched time.Time
+}
+
+// Tokens holds response of token endpoint.
+type Tokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	resp, err := p.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d of %s", resp.StatusCode, u)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// Discover fills endpoints using provider's discovery document.
+func (p *Provider) Discover() error {
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	err := p.getJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return err
+	}
+	if doc.Issuer != p.Issuer {
+		return errors.New("Discovered issuer " + doc.Issuer + " doesn't match " + p.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return errors.New("Incomplete discovery document of " + p.Issuer)
+	}
+	p.AuthorizationEndpoint = doc.AuthorizationEndpoint
+	p.TokenEndpoint = doc.TokenEndpoint
+	p.JWKSURI = doc.JWKSURI
+	return nil
+}
+
+// codeChallenge returns S256 PKCE challenge of verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns URL of provider's authorization page.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange trades authorization code for tokens. Confidential clients
+// authenticate with HTTP basic authentication.
+func (p *Provider) Exchange(code, verifier string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		Tokens
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("Token request failed with status %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("Token response without ID token.")
+	}
+	return &tokens.Tokens, nil
+}
+
+// signingKeys returns cached provider keys. They are fetched again when cache
+// expires or when token is signed with unknown key, which happens after the
+// provider rotates its keys.
+func (p *Provider) signingKeys(kid string) ([]security.JWTKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	age := time.Since(p.keysFetched)
+	known := false
+	for _, k := range p.keys {
+		known = known || k.ID == kid
+	}
+	if p.keys != nil && age < jwksCacheTTL && (known || age < jwksMinRefresh) {
+		return p.keys, nil
+	}
+
+	var set json.RawMessage
+	if err := p.getJSON(p.JWKSURI, &set); err != nil {
+		if p.keys != nil {
+			// provider may be down for a moment, old keys are still valid
+			fmt.Println("Cannot refresh provider keys:", err)
+			return p.keys, nil
+		}
+		return nil, err
+	}
+	keys, err := security.ParseJWKS(set)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	return keys, nil
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce of ID
+// token and returns its claims.
+func (p *Provider) VerifyIDToken(token, nonce string) (security.JWTClaims, error) {
+	var header struct {
+		Kid string `json:"kid"`
+	}
+	if i := strings.Index(token, "."); i > 0 {
+		if b, err := base64.RawURLEncoding.DecodeString(token[:i]); err == nil {
+			json.Unmarshal(b, &header)
+		}
+	}
+	keys, err := p.signingKeys(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	v := security.JWTValidation{Issuer: p.Issuer, Audience: p.ClientID, Leeway: time.Minute}
+	claims, err := security.ParseJWT(token, keys, v)
+	if err != nil {
+		return nil, err
+	}
+	// token issued to several clients has to name this one as authorized party
+	if aud, ok := claims["aud"].([]interface{}); ok && len(aud) > 1 && claims.String("azp") != p.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if nonce == "" || claims.String("nonce") != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+// Identity returns login of user identified by ID token claims.
+func (p *Provider) Identity(claims security.JWTClaims) (string, error) {
+	if p.MapIdentity != nil {
+		return p.MapIdentity(claims)
+	}
+	claim := p.LoginClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	// address not verified by provider could belong to someone else
+	if verified, _ := claims["email_verified"].(bool); claim == "email" && !verified {
+		return "", errors.New("Email address not verified by provider.")
+	}
+	login := claims.String(claim)
+	if login == "" {
+		return "", errors.New("ID token without " + claim + " claim.")
+	}
+	return login, nil
+}
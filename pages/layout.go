@@ -0,0 +1,186 @@
+package pages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// subdirectory of templates directory holding layouts
+	layoutsDir = "layouts"
+)
+
+var (
+	// page or layout declares its layout with comment in the first line:
+	// {{/* layout: base.html */}}
+	layoutDirective = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*layout:\s*(\S+?)\s*\*/\s*-?\}\}`)
+
+	// template sets of pages by file name
+	pageSets map[string]*pageSet
+)
+
+// pageSet is template set of single page.
+type pageSet struct {
+	templates *template.Template
+	// template executed to render the page, its outermost layout
+	entry string
+}
+
+// layoutOf returns layout declared by template source, empty if there is none.
+func layoutOf(source string) string {
+	if m := layoutDirective.FindStringSubmatch(source); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// readTemplates returns sources of all files under directory by their paths
+// relative to it. Hidden files and directories are skipped.
+func readTemplates(fsys fs.FS, directory string) (map[string]string, error) {
+	sources := make(map[string]string)
+	err := fs.WalkDir(fsys, directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == directory && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if name != directory && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		rel := name
+		if directory != "." {
+			rel = strings.TrimPrefix(name, directory+"/")
+		}
+		sources[rel] = string(data)
+		return nil
+	})
+	return sources, err
+}
+
+// isPage reports if template of given relative path is a page, pages are
+// files directly in templates directory.
+func isPage(name string) bool {
+	matched, _ := path.Match("*.*", name)
+	return matched
+}
+
+// loadPageSets builds template set of every page in directory. Shared base
+// set holds partials (files in subdirectories other than layouts, named by
+// their relative paths, e.g. "partials/nav.html") and pages without layout.
+// Page declaring layout gets clone of the base with its layouts, outermost
+// first, and the page itself parsed into it, so blocks defined by the page
+// override blocks of layouts without clashing with other pages.
+func loadPageSets(fsys fs.FS, directory string) (map[string]*pageSet, error) {
+	sources, err := readTemplates(fsys, directory)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	base := template.New("root").Funcs(funcMap)
+	for _, name := range names {
+		if strings.HasPrefix(name, layoutsDir+"/") || isPage(name) && layoutOf(sources[name]) != "" {
+			continue
+		}
+		if _, err := base.New(name).Parse(sources[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	sets := make(map[string]*pageSet)
+	for _, name := range names {
+		if !isPage(name) {
+			continue
+		}
+		chain, err := layoutChain(name, sources)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) == 0 {
+			sets[name] = &pageSet{base, name}
+			continue
+		}
+
+		t, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		for _, layout := range append(chain, name) {
+			if _, err := t.New(layout).Parse(sources[layout]); err != nil {
+				return nil, err
+			}
+		}
+		sets[name] = &pageSet{t, chain[0]}
+	}
+	return sets, nil
+}
+
+// layoutChain returns layouts of page, outermost first.
+func layoutChain(page string, sources map[string]string) ([]string, error) {
+	var chain []string
+	seen := map[string]bool{page: true}
+	for layout := layoutOf(sources[page]); layout != ""; {
+		name := layoutsDir + "/" + layout
+		source, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("Layout %s of template %s not found.", layout, page)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Layouts of template %s form a cycle.", page)
+		}
+		seen[name] = true
+		chain = append([]string{name}, chain...)
+		layout = layoutOf(source)
+	}
+	return chain, nil
+}
+
+// HasPage reports if template with given name can be rendered with
+// ExecutePage.
+func HasPage(name string) bool {
+	if _, ok := pageSets[name]; ok {
+		return true
+	}
+	return TemplatesRoot != nil && TemplatesRoot.Lookup(name) != nil
+}
+
+// ExecutePage renders page with given file name, e.g. "index.html", within
+// its layout. Page declares layout from layouts subdirectory in its first
+// line and overrides blocks of the layout with define actions:
+//
+//	{{/* layout: base.html */}}
+//	{{define "title"}}Home{{end}}
+//	{{define "content"}}{{template "partials/nav.html" .}}...{{end}}
+//
+// Templates defined in flat TemplatesRoot namespace can be rendered too.
+func ExecutePage(w io.Writer, name string, data interface{}) error {
+	if set, ok := pageSets[name]; ok {
+		return set.templates.ExecuteTemplate(w, set.entry, data)
+	}
+	if TemplatesRoot == nil {
+		return fmt.Errorf("Template %s not found.", name)
+	}
+	return TemplatesRoot.ExecuteTemplate(w, name, data)
+}
@@ -3,7 +3,9 @@ package pages
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 	"text/template"
@@ -28,20 +30,54 @@ const (
 var (
 	funcMap       map[string]interface{} = template.FuncMap{}
 	TemplatesRoot *template.Template
+	// filesystem templates are read from, nil means start directory on disk
+	templatesFS fs.FS
 )
 
+// SetTemplatesFS makes templates to be read from fsys, e.g. embed.FS compiled
+// into the binary. Template directories are relative to its root. Nil restores
+// the default, start directory on disk.
+func SetTemplatesFS(fsys fs.FS) {
+	templatesFS = fsys
+}
+
+// TemplatesFS returns filesystem templates are read from.
+func TemplatesFS() fs.FS {
+	if templatesFS != nil {
+		return templatesFS
+	}
+	if settings.StartDir == "" {
+		return os.DirFS(".")
+	}
+	return os.DirFS(settings.StartDir)
+}
+
+// TemplatesReloadable reports if templates are read from disk, only then
+// -reload-templates picks up their changes.
+func TemplatesReloadable() bool {
+	return templatesFS == nil
+}
+
 func LoadTemplates(directory string) {
 	funcMap["roleOrHigher"] = session.RoleOrHigher
 	funcMap["roleOrLower"] = session.RoleOrLower
+	funcMap["can"] = session.Can
+	funcMap["hasRole"] = session.HasRole
 	funcMap["redirect"] = router.Redirect
 	var err error
-	TemplatesRoot, err = template.New("root").Funcs(funcMap).ParseGlob(settings.StartDir + directory + "/*.*")
+	TemplatesRoot, err = template.New("root").Funcs(funcMap).ParseFS(TemplatesFS(), path.Join(path.Clean(directory), "*.*"))
 
 	if err == nil {
 		TemplatesRoot.Funcs(funcMap)
 	} else if !(!settings.RequireTemplates && strings.Contains(err.Error(), "pattern matches no files")) {
 		panic(err)
 	}
+
+	sets, err := loadPageSets(TemplatesFS(), path.Clean(directory))
+	if err != nil {
+		panic(err)
+	}
+	pageSets = sets
 }
 
 func RegisterFunction(name string, function interface{}) {
@@ -180,7 +216,7 @@ func processNormalChunk(chunk string) PagePart {
 
 func LoadPageTemplate(name string) (*Page, error) {
 	fmt.Println("Loading template:", name)
-	rawData, err := ioutil.ReadFile(settings.StartDir + "templates/" + name + ".html")
+	rawData, err := fs.ReadFile(TemplatesFS(), "templates/"+name+".html")
 	if err != nil {
 		panic(err)
 	}
@@ -1,7 +1,9 @@
 package pages
 
 import (
+	"bytes"
 	"testing"
+	"testing/fstest"
 )
 
 func assert(trueStatement bool, msg string) {
@@ -16,3 +18,57 @@ var (
 
 func TestTemplates(t *testing.T) {
 }
+
+func TestTemplatesFS(t *testing.T) {
+	_t = t
+	assert(TemplatesReloadable(), "Templates on disk should be reloadable.")
+	SetTemplatesFS(fstest.MapFS{
+		"views/index.html":    {Data: []byte(`Hello {{.name}}`)},
+		"templates/part.html": {Data: []byte(`<p>part</p>`)},
+	})
+	defer SetTemplatesFS(nil)
+	assert(!TemplatesReloadable(), "Templates from other filesystems should not be reloaded.")
+
+	LoadTemplates("views/")
+	buff := new(bytes.Buffer)
+	err := TemplatesRoot.ExecuteTemplate(buff, "index.html", map[string]string{"name": "upendo"})
+	assert(err == nil && buff.String() == "Hello upendo", "Templates should be loaded from filesystem.")
+
+	page, err := LoadPageTemplate("part")
+	assert(err == nil && len(page.Parts) == 1 && page.Parts[0].Content() == "<p>part</p>", "Page template should be loaded from filesystem.")
+}
+
+func TestLayouts(t *testing.T) {
+	_t = t
+	SetTemplatesFS(fstest.MapFS{
+		"templates/layouts/root.html":    {Data: []byte(`<html>{{block "body" .}}{{end}}</html>`)},
+		"templates/layouts/base.html":    {Data: []byte("{{/* layout: root.html */}}\n" + `{{define "body"}}<h1>{{block "title" .}}Site{{end}}</h1>{{block "content" .}}{{end}}{{end}}`)},
+		"templates/partials/nav.html":    {Data: []byte(`<nav>{{.user}}</nav>`)},
+		"templates/index.html":           {Data: []byte("{{/* layout: base.html */}}\n" + `{{define "content"}}{{template "partials/nav.html" .}}index{{end}}`)},
+		"templates/about.html":           {Data: []byte("{{- /* layout: base.html */ -}}\n" + `{{define "title"}}About{{end}}{{define "content"}}about{{end}}`)},
+		"templates/plain.html":           {Data: []byte(`plain {{template "partials/nav.html" .}}`)},
+		"templates/partials/.draft.html": {Data: []byte(`{{`)},
+	})
+	defer SetTemplatesFS(nil)
+	LoadTemplates("templates")
+
+	render := func(name string) string {
+		buff := new(bytes.Buffer)
+		err := ExecutePage(buff, name, map[string]string{"user": "alice"})
+		assert(err == nil, "Page should be rendered: "+name)
+		return buff.String()
+	}
+	assert(render("index.html") == "<html><h1>Site</h1><nav>alice</nav>index</html>", "Page should fill blocks of nested layouts.")
+	assert(render("about.html") == "<html><h1>About</h1>about</html>", "Blocks of other pages should not clash.")
+	assert(render("plain.html") == "plain <nav>alice</nav>", "Page without layout should be rendered as is.")
+	assert(HasPage("about.html") && !HasPage("partials/nav.html") && !HasPage("missing.html"), "Only pages should be found.")
+
+	_, err := loadPageSets(fstest.MapFS{
+		"layouts/a.html": {Data: []byte(`{{/* layout: b.html */}}`)},
+		"layouts/b.html": {Data: []byte(`{{/* layout: a.html */}}`)},
+		"page.html":      {Data: []byte(`{{/* layout: a.html */}}`)},
+	}, ".")
+	assert(err != nil, "Layout cycle should be reported.")
+	_, err = loadPageSets(fstest.MapFS{"page.html": {Data: []byte(`{{/* layout: missing.html */}}`)}}, ".")
+	assert(err != nil, "Missing layout should be reported.")
+}
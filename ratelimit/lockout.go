@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Lockout blocks keys (e.g. logins) after too many failures. Every failure
+// above Threshold doubles lock duration, starting with Base and up to Max.
+// Success resets the key.
+type Lockout struct {
+	Name      string
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Store     Store
+}
+
+// NewLockout creates lockout using default store.
+func NewLockout(name string, threshold int, base, max time.Duration) *Lockout {
+	return &Lockout{name, threshold, base, max, defaultStore}
+}
+
+// Locked returns how long key stays locked, 0 if it isn't. Only Fail creates
+// state of keys, so checking keys doesn't fill the store.
+func (l *Lockout) Locked(key string) (time.Duration, error) {
+	s, err := l.Store.Get(l.Name + ":" + key)
+	if err != nil {
+		return 0, err
+	}
+	if now := time.Now(); s.Until.After(now) {
+		return s.Until.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail counts failure of key and returns how long it's locked now.
+func (l *Lockout) Fail(key string) (time.Duration, error) {
+	var lock time.Duration
+	now := time.Now()
+	// failures are forgotten after the longest lock
+	err := l.Store.Update(l.Name+":"+key, 2*l.Max, func(s *State) {
+		s.Count++
+		over := s.Count - l.Threshold
+		if over <= 0 {
+			return
+		}
+		lock = l.Max
+		if over <= 32 {
+			if d := l.Base << uint(over-1); d > 0 && d < l.Max {
+				lock = d
+			}
+		}
+		s.Until = now.Add(lock)
+	})
+	return lock, err
+}
+
+// Succeed resets failures of key.
+func (l *Lockout) Succeed(key string) error {
+	return l.Store.Update(l.Name+":"+key, time.Second, func(s *State) {
+		*s = State{}
+	})
+}
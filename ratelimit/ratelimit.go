@@ -0,0 +1,247 @@
+// Package ratelimit limits how often routes can be requested using token
+// bucket or sliding window algorithm, and provides progressive lockout for
+// failed logins. Limiters are attached to routes with Limit:
+//
+//	router.Add("POST", "/api/comments", api, "AddComment")
+//	ratelimit.Limit("POST", "/api/comments", ratelimit.New("comments", ratelimit.Rate{Limit: 10, Per: time.Minute}, ratelimit.ByUser))
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/solgar/upendo/controller"
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/session"
+)
+
+// Algorithms of Rate.
+const (
+	// TokenBucket allows bursts of Burst requests, tokens are refilled at
+	// Limit per Per.
+	TokenBucket = "token-bucket"
+	// SlidingWindow allows Limit requests in any period of Per length
+	// (approximated with weighted previous window).
+	SlidingWindow = "sliding-window"
+
+	// route option set by Limit
+	limitersOption = "ratelimit.limiters"
+)
+
+var (
+	defaultStore Store = NewMemoryStore()
+	checkOnce    sync.Once
+)
+
+// Rate describes how many requests are allowed.
+type Rate struct {
+	Limit int
+	Per   time.Duration
+	// token bucket capacity, Limit is used if not set
+	Burst int
+	// TokenBucket (default) or SlidingWindow
+	Algorithm string
+}
+
+// Result describes outcome of a request counted by Limiter.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until limit is fully available again
+	Reset time.Duration
+	// time until next request is allowed, set only when request is denied
+	RetryAfter time.Duration
+}
+
+// KeyFunc returns key counted by limiter for current request.
+type KeyFunc func(c map[string]interface{}) string
+
+// Limiter counts requests by keys.
+type Limiter struct {
+	Name  string
+	Rate  Rate
+	Key   KeyFunc
+	Store Store
+}
+
+// SetStore replaces in memory store used by limiters created afterwards.
+func SetStore(store Store) {
+	defaultStore = store
+}
+
+// New creates limiter using default store. Name separates keys of limiters
+// sharing a store.
+func New(name string, rate Rate, key KeyFunc) *Limiter {
+	if rate.Limit <= 0 || rate.Per <= 0 {
+		panic("Rate limit and period have to be positive.")
+	}
+	if rate.Algorithm == "" {
+		rate.Algorithm = TokenBucket
+	}
+	if rate.Burst <= 0 {
+		rate.Burst = rate.Limit
+	}
+	return &Limiter{name, rate, key, defaultStore}
+}
+
+// ByIP counts requests by remote IP address.
+func ByIP(c map[string]interface{}) string {
+	return RemoteIP(c["request"].(*http.Request))
+}
+
+// ByUser counts requests by logged in user, anonymous requests are counted by
+// IP address.
+func ByUser(c map[string]interface{}) string {
+	if s, _ := c["session"].(*session.Session); !s.Anonymous() {
+		return "user:" + strconv.Itoa(s.UserID)
+	}
+	return "ip:" + ByIP(c)
+}
+
+// RemoteIP returns IP address of the client.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Allow counts request with given key.
+func (l *Limiter) Allow(key string) (Result, error) {
+	var result Result
+	now := time.Now()
+	key = l.Name + ":" + key
+	var err error
+	if l.Rate.Algorithm == SlidingWindow {
+		err = l.Store.Update(key, 2*l.Rate.Per, func(s *State) { result = l.slidingWindow(s, now) })
+	} else {
+		ttl := time.Duration(float64(l.Rate.Per) * float64(l.Rate.Burst) / float64(l.Rate.Limit))
+		err = l.Store.Update(key, ttl, func(s *State) { result = l.tokenBucket(s, now) })
+	}
+	return result, err
+}
+
+func (l *Limiter) tokenBucket(s *State, now time.Time) Result {
+	perToken := float64(l.Rate.Per) / float64(l.Rate.Limit)
+	capacity := float64(l.Rate.Burst)
+	if s.Updated.IsZero() {
+		s.Tokens = capacity
+	} else {
+		s.Tokens = math.Min(capacity, s.Tokens+float64(now.Sub(s.Updated))/perToken)
+	}
+	s.Updated = now
+
+	result := Result{Limit: l.Rate.Burst}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - s.Tokens) * perToken)
+	}
+	result.Remaining = int(s.Tokens)
+	result.Reset = time.Duration((capacity - s.Tokens) * perToken)
+	return result
+}
+
+func (l *Limiter) slidingWindow(s *State, now time.Time) Result {
+	window := l.Rate.Per
+	start := now.Truncate(window)
+	if !s.Start.Equal(start) {
+		if s.Start.Equal(start.Add(-window)) {
+			s.PrevCount = s.Count
+		} else {
+			s.PrevCount = 0
+		}
+		s.Count = 0
+		s.Start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(s.PrevCount)*weight + float64(s.Count)
+
+	result := Result{Limit: l.Rate.Limit, Reset: window - elapsed}
+	if estimated+1 <= float64(l.Rate.Limit) {
+		s.Count++
+		estimated++
+		result.Allowed = true
+	} else if s.Count >= l.Rate.Limit {
+		result.RetryAfter = window - elapsed
+	} else {
+		// wait until weighted previous window leaves room for one request
+		needed := (estimated + 1 - float64(l.Rate.Limit)) / float64(s.PrevCount)
+		result.RetryAfter = time.Duration(needed * float64(window))
+	}
+	result.Remaining = l.Rate.Limit - int(math.Ceil(estimated))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	return result
+}
+
+// Limit attaches limiters to route added with router.Add. Requests exceeding
+// any of them are routed to ErrorsRouting[429].
+func Limit(method, path string, limiters ...*Limiter) {
+	checkOnce.Do(func() {
+		router.AddPreRouteFunc(Check)
+	})
+	router.SetRouteOption(method, path, limitersOption, limiters)
+}
+
+// SetHeaders adds RateLimit-* headers describing result, and Retry-After if
+// request was denied.
+func SetHeaders(c map[string]interface{}, result Result) {
+	controller.AddHeader(c, "RateLimit-Limit", strconv.Itoa(result.Limit))
+	controller.AddHeader(c, "RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	controller.AddHeader(c, "RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	if !result.Allowed {
+		controller.AddHeader(c, "Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+	}
+}
+
+// seconds rounds duration up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Check is pre route function counting requests of routes with limiters.
+// Headers of the most restrictive limiter are sent.
+func Check(cv reflect.Value) {
+	c := controller.CMap(cv)
+	limiters, _ := router.RouteOption(c, limitersOption).([]*Limiter)
+	if len(limiters) == 0 || router.ErrorPage(c) {
+		return
+	}
+	controller.CheckSession(cv)
+
+	var reported *Result
+	for _, l := range limiters {
+		result, err := l.Allow(l.Key(c))
+		if err != nil {
+			// don't block users when store fails
+			fmt.Println("Cannot check rate limit:", err)
+			continue
+		}
+		if reported == nil || !result.Allowed || result.Remaining < reported.Remaining {
+			reported = &result
+		}
+		if !result.Allowed {
+			break
+		}
+	}
+	if reported == nil {
+		return
+	}
+	SetHeaders(c, *reported)
+	if !reported.Allowed {
+		router.RouteToError(c, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/solgar/upendo/session"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+)
+
+func TestTokenBucket(t *testing.T) {
+	_t = t
+	l := New("test", Rate{Limit: 2, Per: time.Second, Burst: 3}, ByIP)
+	s := &State{}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		r := l.tokenBucket(s, now)
+		assert(r.Allowed && r.Remaining == 2-i, "Burst should be allowed.")
+	}
+	r := l.tokenBucket(s, now)
+	assert(!r.Allowed && r.RetryAfter == 500*time.Millisecond, "Empty bucket should deny request.")
+	assert(r.Reset == 1500*time.Millisecond, "Reset should be time to refill bucket.")
+
+	r = l.tokenBucket(s, now.Add(500*time.Millisecond))
+	assert(r.Allowed && r.Remaining == 0, "Token should be refilled.")
+	r = l.tokenBucket(s, now.Add(time.Hour))
+	assert(r.Allowed && r.Remaining == 2, "Bucket should not exceed its capacity.")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	_t = t
+	l := New("test", Rate{Limit: 4, Per: time.Minute, Algorithm: SlidingWindow}, ByIP)
+	s := &State{}
+	start := time.Now().Truncate(time.Minute)
+
+	for i := 0; i < 4; i++ {
+		assert(l.slidingWindow(s, start.Add(time.Second)).Allowed, "Requests within limit should be allowed.")
+	}
+	r := l.slidingWindow(s, start.Add(2*time.Second))
+	assert(!r.Allowed && r.Remaining == 0 && r.RetryAfter == 58*time.Second, "Request over limit should be denied.")
+
+	// 4 requests in previous window weighted 0.5
+	r = l.slidingWindow(s, start.Add(90*time.Second))
+	assert(r.Allowed && r.Remaining == 1, "Previous window should be weighted.")
+	r = l.slidingWindow(s, start.Add(90*time.Second))
+	assert(r.Allowed, "Second request should fit.")
+	r = l.slidingWindow(s, start.Add(90*time.Second))
+	assert(!r.Allowed && r.RetryAfter == 15*time.Second, "Retry should wait until previous window weighs less.")
+
+	r = l.slidingWindow(s, start.Add(5*time.Minute))
+	assert(r.Allowed && r.Remaining == 3, "Old windows should be forgotten.")
+}
+
+func TestKeys(t *testing.T) {
+	_t = t
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[::1]:4321"
+	assert(ByIP(map[string]interface{}{"request": r}) == "::1", "IPv6 address should be parsed.")
+	c := map[string]interface{}{"request": r, "session": &session.Session{UserID: 7, UserName: "alice"}}
+	assert(ByUser(c) == "user:7", "Logged in user should be counted by id.")
+	assert(ByUser(map[string]interface{}{"request": r}) == "ip:::1", "Anonymous user should be counted by IP.")
+}
+
+func TestLockout(t *testing.T) {
+	_t = t
+	store := NewMemoryStore()
+	l := &Lockout{"login", 2, time.Minute, 5 * time.Minute, store}
+
+	for i := 0; i < 2; i++ {
+		lock, _ := l.Fail("alice")
+		assert(lock == 0, "Failures below threshold should not lock.")
+	}
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for _, e := range expected {
+		lock, _ := l.Fail("alice")
+		assert(lock == e, "Lock should grow progressively up to max.")
+	}
+	left, _ := l.Locked("alice")
+	assert(left > 4*time.Minute, "Login should be locked.")
+	for _, login := range []string{"bob", "carol", "dave"} {
+		left, _ = l.Locked(login)
+		assert(left == 0, "Other login should not be locked.")
+	}
+	assert(store.Len() == 1, "Checking logins should not add keys.")
+
+	l.Succeed("alice")
+	left, _ = l.Locked("alice")
+	assert(left == 0, "Success should reset lock.")
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// expired entries are removed at most this often
+	sweepInterval = time.Minute
+)
+
+// State is kept by Store for every key. Token bucket uses Tokens and Updated,
+// sliding window uses Count, PrevCount and Start, Lockout uses Count and Until.
+type State struct {
+	Tokens    float64
+	Updated   time.Time
+	Count     int
+	PrevCount int
+	Start     time.Time
+	Until     time.Time
+}
+
+// Store is the interface used to keep limiter state.
+type Store interface {
+	// Update calls f with state kept under key (zero State if there is none)
+	// and keeps modified state for ttl. Update has to be atomic.
+	Update(key string, ttl time.Duration, f func(s *State)) error
+	// Get returns state kept under key, zero State if there is none. It
+	// doesn't create nor refresh the key.
+	Get(key string) (State, error)
+}
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// MemoryStore keeps limiter state in process memory, expired entries are
+// removed periodically.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	entries   map[string]*memoryEntry
+	nextSweep time.Time
+}
+
+// NewMemoryStore creates empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (m *MemoryStore) Update(key string, ttl time.Duration, f func(s *State)) error {
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if now.After(m.nextSweep) {
+		for k, v := range m.entries {
+			if now.After(v.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	f(&e.state)
+	e.expires = now.Add(ttl)
+	return nil
+}
+
+func (m *MemoryStore) Get(key string) (State, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return State{}, nil
+	}
+	return e.state, nil
+}
+
+// Len returns number of kept keys.
+func (m *MemoryStore) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.entries)
+}
@@ -1,10 +1,14 @@
 package router
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+
+	"github.com/solgar/upendo/settings"
 )
 
 func assert(trueStatement bool, msg string) {
@@ -73,6 +77,12 @@ func TestRoutingEntryCreation(t *testing.T) {
 
 	e, err = createRoutingEntry("GET", "/path/:with/two/:params")
 	assert(err != nil && e == nil, "Two params not allowed")
+
+	e, err = createRoutingEntry("GET", "/static/*file")
+	assert(err == nil && e.key == "GET /static/*" && e.wildcard, "Wrong wildcard path.")
+
+	e, err = createRoutingEntry("GET", "/static/*file/more")
+	assert(err != nil && e == nil, "Wildcard has to end path.")
 }
 
 func TestFindingRoutingEntry(t *testing.T) {
@@ -92,6 +102,15 @@ func TestFindingRoutingEntry(t *testing.T) {
 
 	e = findRoutingEntry("GET", "/no/path")
 	assert(e == nil, "Wrong key.")
+
+	Add("GET", "/static/*file", c, "dummyFunc")
+	Add("GET", "/static/css/*file", c, "dummyFunc")
+	e = findRoutingEntry("GET", "/static/img/a/b.png")
+	assert(e != nil && e.key == "GET /static/*", "Wildcard should match nested path.")
+	e = findRoutingEntry("GET", "/static/css/site.css")
+	assert(e != nil && e.key == "GET /static/css/*", "The longest wildcard prefix should win.")
+	e = findRoutingEntry("GET", "/path/with/param")
+	assert(e != nil && e.key == "GET /path/with/:param", "Parameter should win over wildcard.")
 }
 
 type testResponseWriter struct{ header http.Header }
@@ -116,3 +135,105 @@ func TestSetHeaderValuesUsingMap(t *testing.T) {
 
 	fmt.Println(w.Header())
 }
+
+type errorTestController map[string]interface{}
+
+func (c errorTestController) Secret() {
+	if RouteOption(c, "public") == nil {
+		RouteToError(c, http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "secret")
+}
+
+func (c errorTestController) File() {
+	c["headers"].(map[string]string)["ETag"] = `"1"`
+	ServeWith(c, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, "file ", c["file"])
+	})
+}
+
+func (c errorTestController) Upload() {
+	AfterRequest(c, func() { (*c["finished"].(*[]string))[0] = "first" })
+	AfterRequest(c, func() { *c["finished"].(*[]string) = []string{"second"} })
+	panic("upload failed")
+}
+
+func (c errorTestController) Error() {
+	fmt.Fprint(c["writer"].(*bytes.Buffer), "error ", c["errorCode"])
+}
+
+func TestRouteToError(t *testing.T) {
+	_t = t
+	settings.RoutingChainMax = 4
+	clearRoutingData()
+	Add("GET", "/secret", errorTestController{}, "Secret")
+	Add("GET", "/public", errorTestController{}, "Secret")
+	Add("GET", "/error/:errorCode", errorTestController{}, "Error")
+	SetRouteOption("GET", "/public", "public", true)
+
+	w := httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("GET", "/secret", nil))
+	assert(w.Code == http.StatusForbidden, "Status code should be kept on error page.")
+	assert(w.Body.String() == "error 403", "Error page should be rendered.")
+
+	w = httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("GET", "/public", nil))
+	assert(w.Code == http.StatusOK && w.Body.String() == "secret", "Route option should be available to handler.")
+}
+
+func TestServeWith(t *testing.T) {
+	_t = t
+	clearRoutingData()
+	Add("GET", "/files/*file", errorTestController{}, "File")
+
+	w := httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("GET", "/files/a/b.txt", nil))
+	assert(w.Code == http.StatusPartialContent && w.Body.String() == "file a/b.txt", "Response should be written by ServeWith function.")
+	assert(w.Header().Get("ETag") == `"1"`, "Controller headers should be applied first.")
+}
+
+func TestAfterRequest(t *testing.T) {
+	_t = t
+	clearRoutingData()
+	Add("POST", "/upload", errorTestController{}, "Upload")
+	Add("GET", "/error/:errorCode", errorTestController{}, "Error")
+	finished := []string{""}
+	AddPreRouteFunc(func(c reflect.Value) {
+		c.SetMapIndex(reflect.ValueOf("finished"), reflect.ValueOf(&finished))
+	})
+	defer func() { preRouteFunctions = nil }()
+
+	w := httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("POST", "/upload", nil))
+	assert(w.Body.String() == "error 500", "Panic should be routed to error page.")
+	assert(len(finished) == 1 && finished[0] == "first", "Functions should be called in reverse order after panic.")
+}
+
+func TestRejectingPreRouteFunc(t *testing.T) {
+	_t = t
+	settings.RoutingChainMax = 4
+	clearRoutingData()
+	Add("POST", "/public", errorTestController{}, "Secret")
+	Add("GET", "/error/:errorCode", errorTestController{}, "Error")
+	ignoreErrorPage := true
+	AddPreRouteFunc(func(cv reflect.Value) {
+		c := cv.Convert(reflect.TypeOf(map[string]interface{}{})).Interface().(map[string]interface{})
+		c["headers"].(map[string]string)["X-Pre-Route"] = c["path"].(string)
+		if !ignoreErrorPage || !ErrorPage(c) {
+			RouteToError(c, http.StatusForbidden)
+		}
+	})
+	defer func() { preRouteFunctions = nil }()
+
+	w := httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("POST", "/public", nil))
+	assert(w.Code == http.StatusForbidden && w.Body.String() == "error 403", "Error page should be rendered.")
+	assert(w.Header().Get("X-Pre-Route") == "/error/403", "Pre route functions should run for error page.")
+
+	ignoreErrorPage = false
+	w = httptest.NewRecorder()
+	RouteRequest(w, httptest.NewRequest("POST", "/public", nil))
+	assert(w.Code == http.StatusForbidden && w.Body.Len() == 0, "Rejected error page should be sent with bare status.")
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/solgar/upendo/listener"
 	"github.com/solgar/upendo/settings"
 )
 
@@ -29,15 +30,18 @@ var (
 		http.StatusNotFound:            "GET /error/404", // NOT FOUND
 		http.StatusMethodNotAllowed:    "GET /error/405",
 		http.StatusNotAcceptable:       "GET /error/406",
+		http.StatusTooManyRequests:     "GET /error/429",
 		http.StatusInternalServerError: "GET /error/500"} // INTERNAL SERVER ERROR
 
 	routingTable       map[string]*routingEntry = make(map[string]*routingEntry)
 	ignores            map[string]int           = make(map[string]int)
+	restrictions       map[string][]string      = make(map[string][]string)
 	paramNameReplacer  *regexp.Regexp           = nil
 	allowedMethods                              = map[string]int{"GET": 1, "HEAD": 1, "POST": 1, "PUT": 1, "DELETE": 1, "TRACE": 1, "OPTIONS": 1, "CONNECT": 1, "PATCH": 1}
 	preRouteFunctions  []func(reflect.Value)    = make([]func(reflect.Value), 0)
 	postRouteFunctions []func(reflect.Value)    = make([]func(reflect.Value), 0)
-	controllersTypes   map[string]reflect.Type  = make(map[string]reflect.Type)
+	requestFilters     []func(http.ResponseWriter, *http.Request) bool
+	controllersTypes   map[string]reflect.Type = make(map[string]reflect.Type)
 )
 
 type routingEntry struct {
@@ -46,11 +50,21 @@ type routingEntry struct {
 	key         string
 	handlerName string
 	controller  reflect.Type
+	// set with SetRouteOption
+	options map[string]interface{}
+	// set for "/*name" parameter matching the rest of the path
+	wildcard bool
 }
 
 type routingContext struct {
 	callChain []string
 	errorCtx  *errorContext
+	// status code of error page routed with RouteToError
+	statusCode int
+	// functions added with AfterRequest
+	finishers []func()
+	// set while error page of RouteToError or panic is rendered, see ErrorPage
+	errorPage bool
 }
 
 func (ctx *routingContext) printCallChain() {
@@ -63,6 +77,13 @@ func (ctx *routingContext) printCallChain() {
 	}
 }
 
+// finish calls functions added with AfterRequest in reverse order.
+func (ctx *routingContext) finish() {
+	for i := len(ctx.finishers) - 1; i >= 0; i-- {
+		ctx.finishers[i]()
+	}
+}
+
 func createRoutingContext(rootCall string) *routingContext {
 	ctx := &routingContext{}
 	if rootCall != "" {
@@ -98,6 +119,16 @@ func createRoutingEntry(method, path string) (*routingEntry, error) {
 	key := method + " "
 
 	entry := &routingEntry{}
+	if star := strings.Index(path, "/*"); star != -1 {
+		entry.varName = path[star+2:]
+		if strings.Contains(path, "/:") || entry.varName == "" || strings.Contains(entry.varName, "/") {
+			return nil, errors.New("Error while creating routing entry: wildcard has to be the only parameter at the end of path: " + path)
+		}
+		entry.varPlace = star
+		entry.wildcard = true
+		entry.key = key + path[:star] + "/*"
+		return entry, nil
+	}
 	entry.varPlace = strings.Index(path, "/:")
 
 	if entry.varPlace != -1 {
@@ -133,7 +164,14 @@ func AddPreRouteFunc(pre func(reflect.Value)) {
 }
 
 func AddPostRouteFunc(post func(reflect.Value)) {
-	postRouteFunctions = append(preRouteFunctions, post)
+	postRouteFunctions = append(postRouteFunctions, post)
+}
+
+// AddRequestFilter adds function called for every request before it's routed.
+// Filter returning true has handled the request itself (e.g. answered CORS
+// preflight) and the request isn't routed.
+func AddRequestFilter(f func(http.ResponseWriter, *http.Request) bool) {
+	requestFilters = append(requestFilters, f)
 }
 
 func AddPath(path string, controller interface{}, methodName string) {
@@ -157,6 +195,30 @@ func Add(method, path string, controller interface{}, methodName string) {
 	controllersTypes[entry.controller.Name()] = entry.controller
 }
 
+// SetRouteOption attaches named option to route added with Add, e.g.
+// authorization requirements. Pre and post route functions can read options
+// of matched route with RouteOption.
+func SetRouteOption(method, path, name string, value interface{}) {
+	entry, err := createRoutingEntry(method, path)
+	if err != nil {
+		panic(err)
+	}
+	e, ok := routingTable[entry.key]
+	if !ok {
+		panic("Cannot set route option: " + entry.key + " not in routing table.")
+	}
+	if e.options == nil {
+		e.options = make(map[string]interface{})
+	}
+	e.options[name] = value
+}
+
+// RouteOption returns option of route which is being handled or nil.
+func RouteOption(c map[string]interface{}, name string) interface{} {
+	options, _ := c["__routeOptions"].(map[string]interface{})
+	return options[name]
+}
+
 func AddIgnoredPath(path string) {
 	ignores[path] = 0
 }
@@ -165,6 +227,33 @@ func RemoveIgnoredPath(path string) {
 	delete(ignores, path)
 }
 
+// RestrictPath makes paths starting with given prefix available only on
+// listeners with given names (see listener package). Requests for such paths
+// received on other listeners are routed as not found.
+func RestrictPath(prefix string, listenerNames ...string) {
+	restrictions[prefix] = listenerNames
+}
+
+func isRestricted(r *http.Request) bool {
+	name := listener.Name(r)
+	for prefix, allowed := range restrictions {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			if a == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
 func RouteRequest(w http.ResponseWriter, r *http.Request) {
 	_, ok := ignores[r.URL.Path]
 	if ok {
@@ -172,6 +261,18 @@ func RouteRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := createRoutingContext("")
+	defer ctx.finish()
+
+	if isRestricted(r) {
+		routeRequestUsingKey(w, r, ErrorsRouting[http.StatusNotFound], ctx)
+		return
+	}
+
+	for _, f := range requestFilters {
+		if f(w, r) {
+			return
+		}
+	}
 
 	if routeRequestSimple(w, r, ctx) == false {
 		routeRequestUsingKey(w, r, ErrorsRouting[http.StatusNotFound], ctx)
@@ -201,7 +302,7 @@ func findRoutingEntry(method, path string) *routingEntry {
 		sepIdx = strings.LastIndex(key[:prevIdx], "/")
 
 		if sepIdx == -1 {
-			return nil
+			return findWildcardEntry(key)
 		}
 
 		k := key[:sepIdx+1] + ":param" + key[prevIdx:]
@@ -212,6 +313,16 @@ func findRoutingEntry(method, path string) *routingEntry {
 	}
 }
 
+// findWildcardEntry returns "/*name" route with the longest prefix of key.
+func findWildcardEntry(key string) *routingEntry {
+	for sepIdx := strings.LastIndex(key, "/"); sepIdx != -1; sepIdx = strings.LastIndex(key[:sepIdx], "/") {
+		if e := routingTable[key[:sepIdx]+"/*"]; e != nil && e.wildcard {
+			return e
+		}
+	}
+	return nil
+}
+
 func MakeCleanParams(params map[string]interface{}) map[string]interface{} {
 	p := make(map[string]interface{})
 	p["__writer"] = params["__writer"]
@@ -242,7 +353,8 @@ func routeRequest(w http.ResponseWriter, r *http.Request, method, path string, c
 		fmt.Println("Error: Call chain exceeded RoutingChainMax.")
 		ctx.printCallChain()
 		// to break call chain
-		success = true
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
 	}
 
 	// recover from any panic and redirect to 500 page
@@ -273,6 +385,7 @@ func routeRequest(w http.ResponseWriter, r *http.Request, method, path string, c
 				stackTraces[r] = errCtx
 				ctx.errorCtx = errCtx
 				criticalSection.Unlock()
+				ctx.errorPage = true
 				routeRequestUsingKey(w, r, ErrorsRouting[http.StatusInternalServerError], ctx)
 			}
 		}
@@ -312,11 +425,18 @@ func routeRequest(w http.ResponseWriter, r *http.Request, method, path string, c
 	controller.SetMapIndex(reflect.ValueOf("method"), reflect.ValueOf(r.Method))
 	controller.SetMapIndex(reflect.ValueOf("path"), reflect.ValueOf(path))
 	controller.SetMapIndex(reflect.ValueOf("headers"), reflect.ValueOf(map[string]string{}))
+	controller.SetMapIndex(reflect.ValueOf("__routingContext"), reflect.ValueOf(ctx))
+	if entry.options != nil {
+		controller.SetMapIndex(reflect.ValueOf("__routeOptions"), reflect.ValueOf(entry.options))
+	}
+	if ctx.statusCode != 0 {
+		controller.SetMapIndex(reflect.ValueOf("StatusCode"), reflect.ValueOf(ctx.statusCode))
+	}
 
 	if entry.varPlace != -1 {
 		varValueBeginStr := path[entry.varPlace+1:]
 		nextSep := strings.Index(varValueBeginStr, "/")
-		if nextSep == -1 {
+		if nextSep == -1 || entry.wildcard {
 			nextSep = len(varValueBeginStr)
 		}
 		varValue := varValueBeginStr[:nextSep]
@@ -325,19 +445,52 @@ func routeRequest(w http.ResponseWriter, r *http.Request, method, path string, c
 
 	for _, f := range preRouteFunctions {
 		f(controller)
+		if controller.MapIndex(reflect.ValueOf("__stopRouting")).IsValid() {
+			break
+		}
 	}
 
 	handlerMethod := controller.MethodByName(entry.handlerName)
 	if !handlerMethod.IsValid() {
 		panic("Cannot route: Controller " + entry.controller.Name() + " doesn't have function " + entry.handlerName + ".")
 	}
-	handlerMethod.Call([]reflect.Value{})
+	if !controller.MapIndex(reflect.ValueOf("__stopRouting")).IsValid() {
+		handlerMethod.Call([]reflect.Value{})
+	}
+
+	// post route functions are called before anything is written, so they
+	// still can set headers and cookies
+	for _, f := range postRouteFunctions {
+		f(controller)
+	}
+
+	// error page is rendered instead, status code is kept; error raised by
+	// error page itself is sent with bare status code
+	if v := controller.MapIndex(reflect.ValueOf("__routeError")); v.IsValid() {
+		ctx.statusCode = v.Interface().(int)
+		setHeaderValues(w, controller)
+		if key, ok := ErrorsRouting[ctx.statusCode]; ok && !ctx.errorPage {
+			ctx.errorPage = true
+			success = routeRequestUsingKey(w, r, key, ctx)
+		}
+		if !success {
+			w.WriteHeader(ctx.statusCode)
+			success = true
+		}
+		return
+	}
 
 	setHeaderValue(w, "Content-Type", controller)
 	setHeaderValue(w, "Location", controller)
 
 	setHeaderValues(w, controller)
 
+	if v := controller.MapIndex(reflect.ValueOf("__serveWith")); v.IsValid() {
+		v.Interface().(func(http.ResponseWriter, *http.Request))(w, r)
+		success = true
+		return
+	}
+
 	statusCode := setStatusCode(w, controller)
 
 	if statusCode != 303 {
@@ -345,11 +498,6 @@ func routeRequest(w http.ResponseWriter, r *http.Request, method, path string, c
 		if buffAfter != nil {
 			buff = buffAfter
 			_, err := w.Write(buff.Bytes())
-
-			for _, f := range postRouteFunctions {
-				f(controller)
-			}
-
 			if err != nil {
 				panic(err)
 			}
@@ -372,6 +520,11 @@ func setHeaderValues(w http.ResponseWriter, c reflect.Value) {
 	if headersV.IsValid() {
 		headers := headersV.Interface().(map[string]string)
 		for k, v := range headers {
+			// request filters may have set Vary already (e.g. CORS)
+			if k == "Vary" {
+				w.Header().Add(k, v)
+				continue
+			}
 			w.Header().Set(k, v)
 		}
 	}
@@ -393,6 +546,48 @@ func Redirect(c map[string]interface{}, path string) int {
 	return 0
 }
 
+// ServeWith makes router call f to write response instead of writing output
+// buffered in "writer". Headers set by the controller and post route functions
+// are applied before f is called, status code has to be written by f. It's
+// meant for responses which shouldn't be buffered, e.g. files served with
+// http.ServeContent.
+func ServeWith(c map[string]interface{}, f func(http.ResponseWriter, *http.Request)) {
+	c["__serveWith"] = f
+}
+
+// AfterRequest makes router call f when request is finished and response is
+// written, also if the handler panicked. It's meant for releasing resources of
+// the request, e.g. temporary files. f isn't called for controllers not
+// created by router.
+func AfterRequest(c map[string]interface{}, f func()) {
+	if ctx, ok := c["__routingContext"].(*routingContext); ok {
+		ctx.finishers = append(ctx.finishers, f)
+	}
+}
+
+// StopRouting makes router skip remaining pre route functions and the handler
+// of current request. It's meant for pre route functions which already
+// prepared response, e.g. redirect. Post route functions are still called.
+func StopRouting(c map[string]interface{}) {
+	c["__stopRouting"] = true
+}
+
+// ErrorPage reports if error page of RouteToError or panic is being rendered.
+// Pre route functions rejecting requests should let such request through, so
+// they don't reject the error page again.
+func ErrorPage(c map[string]interface{}) bool {
+	ctx, ok := c["__routingContext"].(*routingContext)
+	return ok && ctx.errorPage
+}
+
+// RouteToError makes router render error page registered in ErrorsRouting for
+// given status code instead of current handler, without redirecting client.
+// Called from pre route function it also skips the handler.
+func RouteToError(c map[string]interface{}, statusCode int) {
+	c["__routeError"] = statusCode
+	StopRouting(c)
+}
+
 func RedirectToError(c map[string]interface{}, errVal int) {
 	c["__redirected"] = true
 	path := ErrorsRouting[errVal][4:]
@@ -0,0 +1,217 @@
+package security
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidJWT is returned when token is malformed, uses unsupported
+	// algorithm, its signature doesn't match or claims are not valid.
+	ErrInvalidJWT = errors.New("Invalid JWT.")
+	// ErrExpiredJWT is returned when token is expired or not valid yet.
+	ErrExpiredJWT = errors.New("JWT expired or not valid yet.")
+)
+
+// JWTKey is HMAC key used to sign (HS256) and verify tokens, or RSA public key
+// verifying tokens signed by someone else (RS256). ID is sent in "kid" header,
+// so tokens signed with old keys can be verified after rotation.
+type JWTKey struct {
+	ID        string
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+}
+
+// verify reports if signature is valid. Algorithm has to match type of the
+// key, so public key can't be used as HMAC secret.
+func (k JWTKey) verify(alg, signingInput string, signature []byte) bool {
+	switch {
+	case alg == "HS256" && len(k.Secret) > 0:
+		return hmac.Equal(signature, jwtSignature(signingInput, k.Secret))
+	case alg == "RS256" && k.PublicKey != nil:
+		digest := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(k.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// ParseJWKS returns RSA signing keys of JSON Web Key Set, other keys are
+// skipped.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, errors.New("Invalid RSA exponent of key: " + k.Kid)
+		}
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		keys = append(keys, JWTKey{ID: k.Kid, PublicKey: publicKey})
+	}
+	return keys, nil
+}
+
+// JWTClaims holds claims of a token, numeric values are float64.
+type JWTClaims map[string]interface{}
+
+// String returns string claim or empty string.
+func (c JWTClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns time of numeric date claim (e.g. "exp").
+func (c JWTClaims) Time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// HasAudience reports if "aud" claim, which can be string or array, contains
+// given audience.
+func (c JWTClaims) HasAudience(audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTValidation describes checks made by ParseJWT. Expiry is always required.
+type JWTValidation struct {
+	// required "iss" claim, not checked if empty
+	Issuer string
+	// audience required in "aud" claim, not checked if empty
+	Audience string
+	// allowed clock difference
+	Leeway time.Duration
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+func jwtSignature(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// SignJWT returns token with given claims signed with HS256.
+func SignJWT(claims JWTClaims, key JWTKey) (string, error) {
+	header, err := json.Marshal(jwtHeader{"HS256", "JWT", key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(jwtSignature(signingInput, key.Secret)), nil
+}
+
+// ParseJWT verifies HS256 or RS256 token with key matching its "kid" (or any
+// key if token has no "kid") and validates its claims.
+func ParseJWT(token string, keys []JWTKey, v JWTValidation) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidJWT
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil || (header.Alg != "HS256" && header.Alg != "RS256") {
+		return nil, ErrInvalidJWT
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidJWT
+	}
+
+	verified := false
+	for _, key := range keys {
+		if header.Kid != "" && key.ID != header.Kid {
+			continue
+		}
+		if key.verify(header.Alg, parts[0]+"."+parts[1], signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidJWT
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrInvalidJWT
+	}
+	if err := validateJWTClaims(claims, v); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func validateJWTClaims(claims JWTClaims, v JWTValidation) error {
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return ErrInvalidJWT
+	}
+	if now.After(exp.Add(v.Leeway)) {
+		return ErrExpiredJWT
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrExpiredJWT
+	}
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return ErrInvalidJWT
+	}
+	if v.Audience != "" && !claims.HasAudience(v.Audience) {
+		return ErrInvalidJWT
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package security
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWT(t *testing.T) {
+	_t = t
+	oldKey := JWTKey{ID: "old", Secret: []byte("old secret of the test key")}
+	newKey := JWTKey{ID: "new", Secret: []byte("new secret of the test key")}
+	v := JWTValidation{Issuer: "upendo", Audience: "api"}
+	claims := JWTClaims{"sub": "alice", "iss": "upendo", "aud": []string{"web", "api"}, "exp": time.Now().Add(time.Minute).Unix()}
+
+	token, err := SignJWT(claims, oldKey)
+	assert(err == nil && strings.Count(token, ".") == 2, "Token should be signed.")
+	parsed, err := ParseJWT(token, []JWTKey{newKey, oldKey}, v)
+	assert(err == nil && parsed.String("sub") == "alice", "Token signed with rotated key should be valid.")
+	_, err = ParseJWT(token, []JWTKey{newKey}, v)
+	assert(err == ErrInvalidJWT, "Token signed with removed key should be rejected.")
+	_, err = ParseJWT(token[:len(token)-2]+"xx", []JWTKey{oldKey}, v)
+	assert(err == ErrInvalidJWT, "Token with modified signature should be rejected.")
+
+	_, err = ParseJWT(token, []JWTKey{oldKey}, JWTValidation{Issuer: "other"})
+	assert(err == ErrInvalidJWT, "Token of other issuer should be rejected.")
+	_, err = ParseJWT(token, []JWTKey{oldKey}, JWTValidation{Audience: "admin"})
+	assert(err == ErrInvalidJWT, "Token for other audience should be rejected.")
+
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	token, _ = SignJWT(claims, newKey)
+	_, err = ParseJWT(token, []JWTKey{newKey}, v)
+	assert(err == ErrExpiredJWT, "Expired token should be rejected.")
+	_, err = ParseJWT(token, []JWTKey{newKey}, JWTValidation{Leeway: 2 * time.Minute})
+	assert(err == nil, "Expiry should allow leeway.")
+
+	delete(claims, "exp")
+	token, _ = SignJWT(claims, newKey)
+	_, err = ParseJWT(token, []JWTKey{newKey}, JWTValidation{})
+	assert(err == ErrInvalidJWT, "Token without expiry should be rejected.")
+
+	// header {"alg":"none"}
+	unsigned := "eyJhbGciOiJub25lIn0." + strings.Split(token, ".")[1] + "."
+	_, err = ParseJWT(unsigned, []JWTKey{newKey}, JWTValidation{})
+	assert(err == ErrInvalidJWT, "Unsigned token should be rejected.")
+}
+
+func TestJWTRS256(t *testing.T) {
+	_t = t
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert(err == nil, "RSA key should be generated.")
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"rsa"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":` + strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10) + `}`))
+	digest := sha256.Sum256([]byte(header + "." + payload))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	token := header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	publicKey := JWTKey{ID: "rsa", PublicKey: &privateKey.PublicKey}
+	claims, err := ParseJWT(token, []JWTKey{publicKey}, JWTValidation{})
+	assert(err == nil && claims.String("sub") == "alice", "RS256 token should be verified with public key.")
+	_, err = ParseJWT(token, []JWTKey{{ID: "rsa", Secret: []byte("secret of the same id")}}, JWTValidation{})
+	assert(err == ErrInvalidJWT, "RS256 token should not be verified with HMAC secret.")
+
+	// public key must not work as HMAC secret
+	hmacToken, _ := SignJWT(JWTClaims{"exp": time.Now().Add(time.Minute).Unix()}, JWTKey{ID: "rsa", Secret: privateKey.PublicKey.N.Bytes()})
+	_, err = ParseJWT(hmacToken, []JWTKey{publicKey}, JWTValidation{})
+	assert(err == ErrInvalidJWT, "HS256 token should not be verified with public key.")
+
+	jwks := `{"keys":[{"kty":"RSA","use":"sig","kid":"rsa","n":"` + base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()) +
+		`","e":"AQAB"},{"kty":"EC","kid":"ec"},{"kty":"RSA","use":"enc","kid":"enc","n":"AQAB","e":"AQAB"}]}`
+	keys, err := ParseJWKS([]byte(jwks))
+	assert(err == nil && len(keys) == 1 && keys[0].ID == "rsa", "Only RSA signing keys should be parsed.")
+	_, err = ParseJWT(token, keys, JWTValidation{})
+	assert(err == nil, "Token should be verified with key from key set.")
+}
@@ -0,0 +1,176 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/solgar/upendo/settings"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password hash algorithms. Hashes are encoded in PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, bcrypt hashes use their own
+// $2a$ format. Bare hex strings are legacy hashes made by EncryptPassword.
+const (
+	HashPBKDF2   = "pbkdf2"
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+
+	// used when settings are not initialized
+	defaultPBKDF2Iterations = 310000
+	defaultBcryptCost       = 12
+	defaultArgon2Memory     = 64 * 1024
+	defaultArgon2Time       = 3
+	defaultArgon2Threads    = 2
+
+	passwordSaltSize = 16
+	passwordKeySize  = 32
+	pbkdf2Prefix     = "$pbkdf2-sha256$"
+	argon2Prefix     = "$argon2id$"
+)
+
+var (
+	// ErrUnknownHash is returned when password hash format isn't recognized.
+	ErrUnknownHash = errors.New("Unknown password hash format.")
+
+	b64 = base64.RawStdEncoding
+)
+
+// passwordParams holds configured algorithm and cost parameters.
+type passwordParams struct {
+	algorithm        string
+	pbkdf2Iterations int
+	bcryptCost       int
+	argon2Memory     uint32
+	argon2Time       uint32
+	argon2Threads    uint8
+}
+
+func currentParams() passwordParams {
+	p := passwordParams{settings.PasswordHash, settings.PasswordPBKDF2Iterations, settings.PasswordBcryptCost,
+		uint32(settings.PasswordArgon2Memory), uint32(settings.PasswordArgon2Time), uint8(settings.PasswordArgon2Threads)}
+	if p.algorithm == "" {
+		p.algorithm = HashArgon2id
+	}
+	if p.pbkdf2Iterations <= 0 {
+		p.pbkdf2Iterations = defaultPBKDF2Iterations
+	}
+	if p.bcryptCost <= 0 {
+		p.bcryptCost = defaultBcryptCost
+	}
+	if p.argon2Memory == 0 {
+		p.argon2Memory = defaultArgon2Memory
+	}
+	if p.argon2Time == 0 {
+		p.argon2Time = defaultArgon2Time
+	}
+	if p.argon2Threads == 0 {
+		p.argon2Threads = defaultArgon2Threads
+	}
+	return p
+}
+
+// HashPassword returns self-describing hash of password made with algorithm
+// and parameters from settings.
+func HashPassword(password string) (string, error) {
+	p := currentParams()
+	if p.algorithm == HashBcrypt {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), p.bcryptCost)
+		return string(h), err
+	}
+
+	salt := RandomBytes(passwordSaltSize)
+	switch p.algorithm {
+	case HashPBKDF2:
+		key := pbkdf2.Key([]byte(password), salt, p.pbkdf2Iterations, passwordKeySize, sha256.New)
+		return fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, p.pbkdf2Iterations, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case HashArgon2id:
+		key := argon2.IDKey([]byte(password), salt, p.argon2Time, p.argon2Memory, p.argon2Threads, passwordKeySize)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, p.argon2Memory, p.argon2Time, p.argon2Threads,
+			b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	}
+	return "", errors.New("Unknown password hash algorithm: " + p.algorithm)
+}
+
+// VerifyPassword reports if password matches the hash. needsRehash is set when
+// the hash was made with other algorithm or parameters than configured ones,
+// new hash should be stored then. salt is used only by legacy hex hashes which
+// were made by EncryptPassword, other formats carry their own salt.
+func VerifyPassword(password, hash, salt string) (ok, needsRehash bool, err error) {
+	p := currentParams()
+	switch {
+	case strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, p.algorithm != HashBcrypt || cost != p.bcryptCost, err
+
+	case strings.HasPrefix(hash, pbkdf2Prefix):
+		var iterations int
+		var encodedSalt, encodedKey string
+		if _, err := fmt.Sscanf(strings.Replace(hash[len(pbkdf2Prefix):], "$", " ", -1), "i=%d %s %s", &iterations, &encodedSalt, &encodedKey); err != nil || iterations <= 0 {
+			return false, false, ErrUnknownHash
+		}
+		rawSalt, key, err := decodeSaltAndKey(encodedSalt, encodedKey)
+		if err != nil {
+			return false, false, err
+		}
+		computed := pbkdf2.Key([]byte(password), rawSalt, iterations, len(key), sha256.New)
+		ok = subtle.ConstantTimeCompare(computed, key) == 1
+		return ok, ok && (p.algorithm != HashPBKDF2 || iterations != p.pbkdf2Iterations), nil
+
+	case strings.HasPrefix(hash, argon2Prefix):
+		var version int
+		var memory, time uint32
+		var threads uint8
+		var encodedSalt, encodedKey string
+		if _, err := fmt.Sscanf(strings.Replace(hash[len(argon2Prefix):], "$", " ", -1), "v=%d m=%d,t=%d,p=%d %s %s",
+			&version, &memory, &time, &threads, &encodedSalt, &encodedKey); err != nil || version != argon2.Version {
+			return false, false, ErrUnknownHash
+		}
+		rawSalt, key, err := decodeSaltAndKey(encodedSalt, encodedKey)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), rawSalt, time, memory, threads, uint32(len(key)))
+		ok = subtle.ConstantTimeCompare(computed, key) == 1
+		return ok, ok && (p.algorithm != HashArgon2id || memory != p.argon2Memory || time != p.argon2Time || threads != p.argon2Threads), nil
+
+	case isLegacyHash(hash):
+		ok = subtle.ConstantTimeCompare([]byte(EncryptPassword(password, salt)), []byte(hash)) == 1
+		return ok, ok, nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := b64.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, ErrUnknownHash
+	}
+	key, err := b64.DecodeString(encodedKey)
+	if err != nil || len(key) == 0 {
+		return nil, nil, ErrUnknownHash
+	}
+	return salt, key, nil
+}
+
+// isLegacyHash reports if hash is bare hex string made by EncryptPassword.
+func isLegacyHash(hash string) bool {
+	if len(hash) != 2*passwordKeySize {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
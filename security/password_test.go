@@ -0,0 +1,53 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/solgar/upendo/settings"
+)
+
+func TestHashPassword(t *testing.T) {
+	_t = t
+	defer func() { settings.PasswordHash = "" }()
+	settings.PasswordBcryptCost = 4
+	settings.PasswordPBKDF2Iterations = 1000
+	settings.PasswordArgon2Memory = 1024
+	settings.PasswordArgon2Time = 1
+	defer func() {
+		settings.PasswordBcryptCost, settings.PasswordPBKDF2Iterations = 0, 0
+		settings.PasswordArgon2Memory, settings.PasswordArgon2Time = 0, 0
+	}()
+
+	prefixes := map[string]string{HashPBKDF2: "$pbkdf2-sha256$i=1000$", HashBcrypt: "$2a$04$", HashArgon2id: "$argon2id$v=19$m=1024,t=1,p=2$"}
+	for algorithm, prefix := range prefixes {
+		settings.PasswordHash = algorithm
+		hash, err := HashPassword("JustSome1pa$$w0rd")
+		assert(err == nil && strings.HasPrefix(hash, prefix), "Hash should be self-describing: "+hash)
+
+		ok, needsRehash, err := VerifyPassword("JustSome1pa$$w0rd", hash, "")
+		assert(ok && !needsRehash && err == nil, "Password should match "+algorithm+" hash.")
+		ok, _, err = VerifyPassword("JustSome2pa$$w0rd", hash, "")
+		assert(!ok && err == nil, "Other password should not match "+algorithm+" hash.")
+	}
+
+	settings.PasswordHash = HashArgon2id
+	settings.PasswordBcryptCost = 5
+	hash, _ := HashPassword("root")
+	settings.PasswordArgon2Time = 2
+	ok, needsRehash, _ := VerifyPassword("root", hash, "")
+	assert(ok && needsRehash, "Hash with outdated parameters should need rehash.")
+}
+
+func TestVerifyLegacyPassword(t *testing.T) {
+	_t = t
+	salt := GenerateRandomSalt()
+	hash := EncryptPassword("root", salt)
+
+	ok, needsRehash, err := VerifyPassword("root", hash, salt)
+	assert(ok && needsRehash && err == nil, "Legacy hash should match and need rehash.")
+	ok, _, _ = VerifyPassword("toor", hash, salt)
+	assert(!ok, "Other password should not match legacy hash.")
+	_, _, err = VerifyPassword("root", "$md5$abc", "")
+	assert(err == ErrUnknownHash, "Unknown format should be reported.")
+}
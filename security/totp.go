@@ -0,0 +1,144 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPDigits is length of TOTP codes.
+	TOTPDigits = 6
+	// TOTPPeriod is how long single TOTP code is valid.
+	TOTPPeriod = 30 * time.Second
+
+	// size of enrollment secrets, 160 bits as recommended by RFC 4226
+	totpSecretSize = 20
+	// recovery codes look like "abcde-12345"
+	recoveryCodeLength = 10
+)
+
+var (
+	// ErrInvalidTOTPSecret is returned when secret isn't valid base32.
+	ErrInvalidTOTPSecret = errors.New("Invalid TOTP secret.")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateTOTPSecret returns new base32 encoded secret to be shared with
+// user's authenticator app.
+func GenerateTOTPSecret() string {
+	return totpEncoding.EncodeToString(RandomBytes(totpSecretSize))
+}
+
+// TOTPURI returns otpauth:// URI of secret, usually shown as QR code during
+// enrollment.
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidTOTPSecret
+	}
+	return key, nil
+}
+
+// hotp computes code of given counter (RFC 4226).
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%uint32(math.Pow10(TOTPDigits)))
+}
+
+// TOTPStep returns time step of given time.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTPCode returns code of secret valid at given time.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, TOTPStep(t)), nil
+}
+
+// VerifyTOTP checks code against steps within window around given time, which
+// tolerates clock drift of window periods. Codes of steps not after lastStep
+// are rejected, so every code can be used only once. Matching step is
+// returned, it has to be stored as lastStep of the next verification.
+func VerifyTOTP(secret, code string, t time.Time, window int, lastStep int64) (int64, bool, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != TOTPDigits {
+		return 0, false, nil
+	}
+	current := TOTPStep(t)
+	for step := current - int64(window); step <= current+int64(window); step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// normalizeRecoveryCode makes codes typed by users comparable.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// GenerateRecoveryCodes returns n single use recovery codes to be shown to the
+// user once, and their hashes to be stored.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string) {
+	policy := PasswordPolicy{Length: recoveryCodeLength, Alphabet: AlphabetLowerDigits}
+	for i := 0; i < n; i++ {
+		code, err := GeneratePassword(policy)
+		if err != nil {
+			panic(err)
+		}
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, HashToken(code))
+	}
+	return codes, hashes
+}
+
+// UseRecoveryCode checks code against stored hashes. If it matches, hashes
+// without the used one are returned and have to replace stored ones.
+func UseRecoveryCode(code string, hashes []string) ([]string, bool) {
+	hash := HashToken(normalizeRecoveryCode(code))
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			left := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+			return left, true
+		}
+	}
+	return hashes, false
+}
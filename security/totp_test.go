@@ -0,0 +1,50 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	_t = t
+	// RFC 6238 test secret "12345678901234567890", codes truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		assert(err == nil && code == expected, "Wrong TOTP code: "+code+", expected: "+expected)
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok, err := VerifyTOTP(secret, "005924", now, 1, 0)
+	assert(err == nil && ok && step == TOTPStep(now), "Current code should be accepted.")
+	_, ok, _ = VerifyTOTP(secret, "005924", now, 1, step)
+	assert(!ok, "Code should not be accepted twice.")
+	_, ok, _ = VerifyTOTP(secret, "005924", now.Add(TOTPPeriod), 1, 0)
+	assert(ok, "Code of previous period should be accepted within window.")
+	_, ok, _ = VerifyTOTP(secret, "005924", now.Add(2*TOTPPeriod), 1, 0)
+	assert(!ok, "Code outside of window should be rejected.")
+	_, _, err = VerifyTOTP("not base32!", "005924", now, 1, 0)
+	assert(err == ErrInvalidTOTPSecret, "Invalid secret should be reported.")
+
+	generated := GenerateTOTPSecret()
+	assert(len(generated) == 32 && generated != GenerateTOTPSecret(), "Secret should have 160 random bits.")
+	uri := TOTPURI("My App", "alice@example.com", generated)
+	assert(strings.HasPrefix(uri, "otpauth://totp/My%20App:alice@example.com?"), "Wrong otpauth URI: "+uri)
+	assert(strings.Contains(uri, "secret="+generated) && strings.Contains(uri, "issuer=My+App"), "URI should contain secret and issuer: "+uri)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	_t = t
+	codes, hashes := GenerateRecoveryCodes(3)
+	assert(len(codes) == 3 && len(hashes) == 3 && len(codes[0]) == 11, "Wrong recovery codes.")
+	assert(!strings.Contains(strings.Join(hashes, ""), codes[0][:5]), "Only hashes of codes should be stored.")
+
+	left, ok := UseRecoveryCode(" "+strings.ToUpper(codes[1])+" ", hashes)
+	assert(ok && len(left) == 2 && len(hashes) == 3, "Code should be accepted regardless of case and spaces.")
+	_, ok = UseRecoveryCode(codes[1], left)
+	assert(!ok, "Recovery code should be usable only once.")
+	_, ok = UseRecoveryCode(strings.Replace(codes[0], "-", "", 1), left)
+	assert(ok, "Code should be accepted without dash.")
+}
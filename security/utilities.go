@@ -1,13 +1,14 @@
 package security
 
 import (
-	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/hex"
-	"math/rand"
-	"time"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -15,42 +16,122 @@ import (
 const (
 	saltSizeBits  = 64
 	saltSizeBytes = int(saltSizeBits / 8)
+
+	// DefaultTokenSize is size in bytes of tokens made by GenerateToken, 256
+	// bits is enough for session ids, reset links and API keys.
+	DefaultTokenSize = 32
+
+	// alphabets which can be used by PasswordPolicy
+	AlphabetLowerDigits  = "abcdefghijklmnopqrstuvwxyz1234567890"
+	AlphabetAlphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	AlphabetPrintable    = AlphabetAlphanumeric + "!#$%&()*+,-./:;<=>?@[]^_{|}~"
 )
 
 var (
-	letterRunes = []rune("abcdefghijklmnopqrstuvwxyz1234567890")
-	initDone    = false
+	initDone = false
+
+	// DefaultPasswordPolicy is used by GenerateRandomPassword.
+	DefaultPasswordPolicy = PasswordPolicy{Length: 8, Alphabet: AlphabetLowerDigits}
 )
 
+// PasswordPolicy describes generated passwords. Password is rejected if its
+// entropy, which is Length * log2(len(Alphabet)), is below MinEntropyBits.
+type PasswordPolicy struct {
+	Length         int
+	Alphabet       string
+	MinEntropyBits float64
+}
+
+// EntropyBits returns entropy of passwords generated with the policy.
+func (p PasswordPolicy) EntropyBits() float64 {
+	return float64(p.Length) * math.Log2(float64(len([]rune(p.Alphabet))))
+}
+
+// Initialize is kept for compatibility. Random values come from crypto/rand,
+// which doesn't need seeding.
 func Initialize() {
 	if initDone {
 		panic("Initialization already done.")
 	}
 	initDone = true
+}
 
-	rand.Seed(time.Now().Unix())
+// RandomBytes returns n bytes read from crypto/rand. It panics if system
+// random source fails, nothing secure can be done without it.
+func RandomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return b
 }
 
-func GenerateRandomPassword() string {
-	b := make([]rune, 8)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+// randomIndex returns uniformly distributed random number from [0, n).
+func randomIndex(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("crypto/rand failed: " + err.Error())
 	}
-	return string(b)
+	return int(i.Int64())
 }
 
-func GenerateRandomSalt() string {
-	buf := new(bytes.Buffer)
-	for i := 0; i < (saltSizeBytes / 4); i++ {
-		err := binary.Write(buf, binary.LittleEndian, rand.Uint32())
-		if err != nil {
-			panic("binary.Write failed: " + err.Error())
+// GenerateToken returns URL safe random token made of size bytes, e.g. for
+// password reset links or API keys.
+func GenerateToken(size int) string {
+	return base64.RawURLEncoding.EncodeToString(RandomBytes(size))
+}
+
+// GenerateTokenHex returns random token made of size bytes encoded as hex.
+func GenerateTokenHex(size int) string {
+	return hex.EncodeToString(RandomBytes(size))
+}
+
+// HashToken returns SHA-256 hash of high entropy token (e.g. API key) which
+// can be stored instead of the token. Use HashPassword for passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratePassword returns random password meeting given policy.
+func GeneratePassword(policy PasswordPolicy) (string, error) {
+	alphabet := []rune(policy.Alphabet)
+	seen := make(map[rune]bool, len(alphabet))
+	for _, r := range alphabet {
+		if seen[r] {
+			return "", errors.New("Password alphabet contains duplicated character: " + string(r))
 		}
+		seen[r] = true
+	}
+	if policy.Length <= 0 || len(alphabet) < 2 {
+		return "", errors.New("Password policy requires positive length and at least two characters in alphabet.")
+	}
+	if policy.EntropyBits() < policy.MinEntropyBits {
+		return "", fmt.Errorf("Password policy gives %.1f bits of entropy, %.1f required.", policy.EntropyBits(), policy.MinEntropyBits)
+	}
+
+	b := make([]rune, policy.Length)
+	for i := range b {
+		b[i] = alphabet[randomIndex(len(alphabet))]
 	}
-	salt := base64.URLEncoding.EncodeToString(buf.Bytes())
-	return salt
+	return string(b), nil
+}
+
+// GenerateRandomPassword returns password made with DefaultPasswordPolicy.
+func GenerateRandomPassword() string {
+	password, err := GeneratePassword(DefaultPasswordPolicy)
+	if err != nil {
+		panic(err)
+	}
+	return password
+}
+
+func GenerateRandomSalt() string {
+	return base64.URLEncoding.EncodeToString(RandomBytes(saltSizeBytes))
 }
 
+// EncryptPassword returns bare hex PBKDF2 hash of password. It's kept to
+// verify existing hashes, use HashPassword for new ones.
 func EncryptPassword(plaintextPassword, salt string) string {
 	k := pbkdf2.Key([]byte(plaintextPassword), []byte(salt), 10000, 32, sha256.New)
 	return hex.EncodeToString(k)
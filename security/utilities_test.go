@@ -2,6 +2,7 @@ package security
 
 import (
 	"encoding/base64"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +52,62 @@ func TestEncryptPassword(t *testing.T) {
 	pass2 = EncryptPassword("root", "69XpoNSrjCVN-2ODxAUVfEsWtGInvc1RIWadYql5maQ=")
 	assert(pass1 != pass2, "Passwords should be different.")
 }
+
+func TestGeneratePassword(t *testing.T) {
+	_t = t
+	policy := PasswordPolicy{Length: 20, Alphabet: AlphabetPrintable, MinEntropyBits: 100}
+	pass, err := GeneratePassword(policy)
+	assert(err == nil && len(pass) == 20, "Password should have requested length.")
+	for _, r := range pass {
+		assert(strings.ContainsRune(AlphabetPrintable, r), "Password should use only alphabet characters.")
+	}
+
+	_, err = GeneratePassword(PasswordPolicy{Length: 8, Alphabet: AlphabetLowerDigits, MinEntropyBits: 64})
+	assert(err != nil, "Policy with too low entropy should be rejected.")
+	_, err = GeneratePassword(PasswordPolicy{Length: 8, Alphabet: "aab"})
+	assert(err != nil, "Alphabet with duplicates should be rejected.")
+	_, err = GeneratePassword(PasswordPolicy{Length: 8, Alphabet: "a"})
+	assert(err != nil, "Single character alphabet should be rejected.")
+}
+
+func TestPasswordDistribution(t *testing.T) {
+	_t = t
+	alphabet := "abcd"
+	counts := make(map[rune]int)
+	pass, _ := GeneratePassword(PasswordPolicy{Length: 40000, Alphabet: alphabet})
+	for _, r := range pass {
+		counts[r]++
+	}
+	// each character is expected 10000 times, standard deviation is ~87
+	for _, r := range alphabet {
+		assert(counts[r] > 9500 && counts[r] < 10500, "Characters should be uniformly distributed.")
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	_t = t
+	token := GenerateToken(DefaultTokenSize)
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	assert(err == nil && len(raw) == DefaultTokenSize, "Token should decode to requested size.")
+	assert(len(GenerateTokenHex(16)) == 32, "Hex token should have two characters per byte.")
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		token = GenerateToken(16)
+		assert(!seen[token], "Tokens should not repeat.")
+		seen[token] = true
+	}
+
+	// every bit should be set in about half of tokens
+	ones := make([]int, 8*16)
+	for i := 0; i < 2000; i++ {
+		for j, b := range RandomBytes(16) {
+			for k := 0; k < 8; k++ {
+				ones[8*j+k] += int(b>>k) & 1
+			}
+		}
+	}
+	for _, n := range ones {
+		assert(n > 850 && n < 1150, "Random bits should be uniformly distributed.")
+	}
+}
@@ -0,0 +1,162 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// browsers don't have to store cookies larger than that (RFC 6265)
+	maxCookieSize = 4096
+	// minimal length of secret used to derive cookie keys
+	minCookieSecretSize = 16
+
+	codecVersionSigned    = 1
+	codecVersionEncrypted = 2
+)
+
+var (
+	// ErrInvalidCookie is returned when session cookie cannot be
+	// authenticated with any of configured keys or is malformed.
+	ErrInvalidCookie = errors.New("Invalid session cookie.")
+)
+
+// CookieTooLargeError is returned when session serialized into a cookie
+// exceeds size which browsers are required to accept.
+type CookieTooLargeError struct {
+	Size int
+}
+
+func (e *CookieTooLargeError) Error() string {
+	return "Session cookie too large: " + strconv.Itoa(e.Size) + " bytes, limit is " + strconv.Itoa(maxCookieSize) + " bytes. Store less data in session or use server side session store."
+}
+
+type codecKey struct {
+	auth []byte
+	aead cipher.AEAD
+}
+
+// cookieCodec serializes sessions into cookie values authenticated with
+// HMAC-SHA256 and optionally encrypted with AES-GCM. Values are created with
+// the first key and verified with any of them, which allows key rotation.
+type cookieCodec struct {
+	keys    []codecKey
+	encrypt bool
+}
+
+func newCookieCodec(secrets []string, encrypt bool) (*cookieCodec, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("No session cookie keys configured.")
+	}
+	c := &cookieCodec{encrypt: encrypt}
+	for _, secret := range secrets {
+		if len(secret) < minCookieSecretSize {
+			return nil, errors.New("Session cookie key too short, at least " + strconv.Itoa(minCookieSecretSize) + " characters required.")
+		}
+		block, err := aes.NewCipher(deriveKey(secret, "upendo session encryption"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, codecKey{deriveKey(secret, "upendo session authentication"), aead})
+	}
+	return c, nil
+}
+
+// deriveKey derives 256 bit key for given purpose, so the same secret is never
+// used directly for two purposes.
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// encode returns cookie value holding given session.
+func (c *cookieCodec) encode(s *Session) (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	key := c.keys[0]
+	body := []byte{codecVersionSigned}
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		body[0] = codecVersionEncrypted
+		body = append(body, nonce...)
+		body = key.aead.Seal(body, nonce, payload, []byte{codecVersionEncrypted})
+	} else {
+		body = append(body, payload...)
+	}
+
+	mac := hmac.New(sha256.New, key.auth)
+	mac.Write(body)
+	value := base64.RawURLEncoding.EncodeToString(mac.Sum(body))
+
+	if size := len(settings.SessionCookieName) + 1 + len(value); size > maxCookieSize {
+		return "", &CookieTooLargeError{size}
+	}
+	return value, nil
+}
+
+// decode returns session held by cookie value or ErrInvalidCookie.
+func (c *cookieCodec) decode(value string) (*Session, error) {
+	if len(value) > maxCookieSize {
+		return nil, ErrInvalidCookie
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < 1+sha256.Size {
+		return nil, ErrInvalidCookie
+	}
+	body, sum := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+
+	for _, key := range c.keys {
+		mac := hmac.New(sha256.New, key.auth)
+		mac.Write(body)
+		if !hmac.Equal(sum, mac.Sum(nil)) {
+			continue
+		}
+
+		payload := body[1:]
+		switch body[0] {
+		case codecVersionSigned:
+			if c.encrypt {
+				// don't accept plain text sessions once encryption is enabled
+				return nil, ErrInvalidCookie
+			}
+		case codecVersionEncrypted:
+			nonceSize := key.aead.NonceSize()
+			if len(payload) < nonceSize {
+				return nil, ErrInvalidCookie
+			}
+			payload, err = key.aead.Open(nil, payload[:nonceSize], payload[nonceSize:], body[:1])
+			if err != nil {
+				return nil, ErrInvalidCookie
+			}
+		default:
+			return nil, ErrInvalidCookie
+		}
+
+		s := &Session{}
+		if err := json.Unmarshal(payload, s); err != nil {
+			return nil, ErrInvalidCookie
+		}
+		return s, nil
+	}
+	return nil, ErrInvalidCookie
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/solgar/upendo/settings"
+)
+
+func TestCookieCodec(t *testing.T) {
+	_t = t
+	settings.SessionCookieName = "data"
+	for _, encrypt := range []bool{false, true} {
+		codec, err := newCookieCodec([]string{"first-secret-0123456789"}, encrypt)
+		assert(err == nil, "Codec should be created.")
+
+		s := &Session{ID: "0a1b", UserID: 7, UserName: "alice"}
+		s.Set("theme", "dark")
+		value, err := codec.encode(s)
+		assert(err == nil, "Session should be encoded.")
+		raw, _ := base64.RawURLEncoding.DecodeString(value)
+		body := raw[1 : len(raw)-sha256.Size]
+		if encrypt {
+			assert(!bytes.Contains(body, []byte("alice")) && !bytes.Contains(body, []byte("dark")), "Encrypted value should not be readable.")
+		} else {
+			payload, _ := json.Marshal(s)
+			assert(bytes.Equal(body, payload), "Signed value should hold the session.")
+		}
+
+		decoded, err := codec.decode(value)
+		assert(err == nil && decoded.UserName == "alice" && decoded.Value("theme") == "dark", "Session should be decoded.")
+
+		for _, i := range []int{1 + len(body)/2, len(raw) - 1} {
+			tampered := append([]byte{}, raw...)
+			tampered[i] ^= 1
+			_, err = codec.decode(base64.RawURLEncoding.EncodeToString(tampered))
+			assert(err == ErrInvalidCookie, "Tampered cookie should be rejected.")
+		}
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	_t = t
+	settings.SessionCookieName = "data"
+	old, _ := newCookieCodec([]string{"old-secret-0123456789"}, true)
+	rotated, _ := newCookieCodec([]string{"new-secret-0123456789", "old-secret-0123456789"}, true)
+	other, _ := newCookieCodec([]string{"other-secret-0123456789"}, true)
+
+	value, _ := old.encode(&Session{ID: "0a1b", UserName: "alice"})
+	decoded, err := rotated.decode(value)
+	assert(err == nil && decoded.UserName == "alice", "Cookie signed with previous key should be accepted.")
+
+	_, err = other.decode(value)
+	assert(err == ErrInvalidCookie, "Cookie signed with unknown key should be rejected.")
+
+	plain, _ := newCookieCodec([]string{"old-secret-0123456789"}, false)
+	value, _ = plain.encode(&Session{ID: "0a1b"})
+	_, err = old.decode(value)
+	assert(err == ErrInvalidCookie, "Unencrypted cookie should be rejected when encryption is enabled.")
+}
+
+func TestCookieCodecLimits(t *testing.T) {
+	_t = t
+	settings.SessionCookieName = "data"
+	_, err := newCookieCodec([]string{"short"}, false)
+	assert(err != nil, "Short secret should be rejected.")
+
+	codec, _ := newCookieCodec([]string{"first-secret-0123456789"}, false)
+	s := &Session{ID: "0a1b"}
+	s.Set("big", strings.Repeat("x", maxCookieSize))
+	_, err = codec.encode(s)
+	_, ok := err.(*CookieTooLargeError)
+	assert(ok, "CookieTooLargeError expected.")
+}
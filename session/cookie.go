@@ -0,0 +1,49 @@
+package session
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/solgar/upendo/settings"
+)
+
+// sessionCookie returns cookie with given value and attributes set according
+// to -session-cookie-* settings.
+func sessionCookie(value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     settings.SessionCookieName,
+		Value:    value,
+		Path:     settings.SessionCookiePath,
+		Domain:   settings.SessionCookieDomain,
+		Secure:   settings.SessionCookieSecure,
+		HttpOnly: settings.SessionCookieHTTPOnly,
+		SameSite: sameSiteMode(settings.SessionCookieSameSite),
+	}
+	if settings.SessionCookieMaxAge > 0 {
+		c.MaxAge = int(settings.SessionCookieMaxAge / time.Second)
+		c.Expires = time.Now().Add(settings.SessionCookieMaxAge)
+	}
+	return c
+}
+
+// expiredSessionCookie returns cookie which removes session cookie from
+// browser. Path and Domain have to match the original cookie.
+func expiredSessionCookie() *http.Cookie {
+	c := sessionCookie("")
+	c.MaxAge = -1
+	c.Expires = time.Unix(0, 0)
+	return c
+}
+
+func sameSiteMode(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
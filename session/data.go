@@ -0,0 +1,86 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrNoValue is returned by Session.Get when there is no value for given key.
+	ErrNoValue = errors.New("No value for given key in session.")
+)
+
+// Set stores value under given key. Value has to be serializable to JSON.
+// Session is marked as modified only if stored value actually changes.
+func (s *Session) Set(key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if old, ok := s.Data[key]; ok && bytes.Equal(old, b) {
+		return nil
+	}
+	if s.Data == nil {
+		s.Data = make(map[string]json.RawMessage)
+	}
+	s.Data[key] = b
+	s.dirty = true
+	return nil
+}
+
+// Get decodes value stored under given key into value pointed by v. If there
+// is no such value ErrNoValue is returned and v is left untouched.
+func (s *Session) Get(key string, v interface{}) error {
+	b, ok := s.Data[key]
+	if !ok {
+		return ErrNoValue
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Value returns value stored under given key decoded into generic types (map,
+// slice, float64, string, bool) or nil if there is no such value. It's meant
+// to be used in templates, e.g. {{.session.Value "cart"}}, so it's safe to
+// call it on nil session.
+func (s *Session) Value(key string) interface{} {
+	var v interface{}
+	if s == nil || s.Get(key, &v) != nil {
+		return nil
+	}
+	return v
+}
+
+// Has reports if there is a value stored under given key.
+func (s *Session) Has(key string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.Data[key]
+	return ok
+}
+
+// Delete removes value stored under given key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.Data[key]; ok {
+		delete(s.Data, key)
+		s.dirty = true
+	}
+}
+
+// Dirty reports if session was modified since it was loaded from store.
+func (s *Session) Dirty() bool {
+	return s.dirty
+}
+
+// copyData returns deep copy of session data.
+func copyData(data map[string]json.RawMessage) map[string]json.RawMessage {
+	if data == nil {
+		return nil
+	}
+	result := make(map[string]json.RawMessage, len(data))
+	for k, v := range data {
+		result[k] = append(json.RawMessage(nil), v...)
+	}
+	return result
+}
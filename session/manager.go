@@ -1,43 +1,61 @@
 package session
 
 import (
-	"crypto/sha256"
-	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/solgar/upendo/database"
 	"github.com/solgar/upendo/security"
 	"github.com/solgar/upendo/settings"
 )
 
 const (
+	// LastAccess is updated in store at most this often (in seconds)
+	renewInterval = 60
+
+	// SessionModeServer keeps sessions in a Store, cookie holds only the id.
+	SessionModeServer = "server"
+	// SessionModeCookie keeps whole session in signed (and optionally
+	// encrypted) cookie, nothing is stored on server.
+	SessionModeCookie = "cookie"
+
 	cmdGetSession    = 1
 	cmdCreateSession = 2
 	cmdRemoveExpired = 3
 	cmdExpireSession = 4
 	cmdExpireUserID  = 5
+	cmdSaveSession   = 6
+	cmdRegenerateID  = 7
+	cmdListUser      = 8
+
+	// session data key set while second factor isn't confirmed
+	twoFactorPendingKey = "__2fa_pending"
+
+	// used when settings aren't initialized before the manager is started
+	defaultIdleTimeout     = time.Hour
+	defaultCleanupInterval = 10 * time.Minute
 )
 
 var (
+	// ErrCookieSessions is returned by operations which need to find
+	// sessions on server, which is impossible with cookie sessions.
+	ErrCookieSessions = errors.New("Operation not supported with cookie sessions.")
+
 	instance         *Manager
-	roleIntValueMap  = map[string]int{"anon": 1, "user": 2, "admin": 3, "root": 4}
 	sessionsFilePath = settings.StartDir + "sessions.json"
 	initDone         = false
 )
 
 type response struct {
-	session *Session
-	err     error
+	session  *Session
+	sessions []*Session
+	err      error
 }
 
 type command struct {
@@ -47,25 +65,94 @@ type command struct {
 	r         *http.Request
 	user      string
 	userID    int
+	session   *Session
 }
 
 // Session object contains information about user such as its name or role. It's
-// used by SessionManager
+// used by SessionManager. Sessions of visitors who didn't log in are anonymous,
+// they have no user name and are stored only after some data is set in them.
 type Session struct {
 	ID         string
 	UserID     int
 	UserName   string
 	UserRole   string
+	UserRoles  []string `json:",omitempty"`
 	Agent      string
 	RemoteAddr string
 	LastAccess int64
+	CreatedAt  int64
+
+	// arbitrary values stored with Set, JSON encoded
+	Data map[string]json.RawMessage `json:",omitempty"`
+
+	// set when LastAccess was updated during current request
+	renewed bool
+	// set when Data was modified during current request
+	dirty bool
+	// set for sessions which exist only during single request
+	transient bool
+}
+
+// Anonymous reports if session doesn't belong to logged in user.
+func (s *Session) Anonymous() bool {
+	return s == nil || s.UserName == ""
+}
+
+// Transient reports if session was created for single request (e.g. by API
+// key or bearer token authentication), such session is never stored.
+func (s *Session) Transient() bool {
+	return s != nil && s.transient
+}
+
+// TwoFactorPending reports if user logged in with password but didn't confirm
+// second factor yet. Such session has no roles until it's confirmed.
+func (s *Session) TwoFactorPending() bool {
+	return s.Has(twoFactorPendingKey)
+}
+
+// SetTwoFactorPending marks session as waiting for second factor or confirms
+// it.
+func (s *Session) SetTwoFactorPending(pending bool) error {
+	if !pending {
+		s.Delete(twoFactorPendingKey)
+		return nil
+	}
+	return s.Set(twoFactorPendingKey, true)
+}
+
+// idleTimeout returns -session-idle-timeout, or its default if it isn't set.
+func idleTimeout() time.Duration {
+	if settings.SessionIdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return settings.SessionIdleTimeout
+}
+
+// cleanupInterval returns -session-cleanup-interval, or its default if it
+// isn't set.
+func cleanupInterval() time.Duration {
+	if settings.SessionCleanupInterval <= 0 {
+		return defaultCleanupInterval
+	}
+	return settings.SessionCleanupInterval
+}
+
+// expired reports if session exceeded idle or absolute timeout at given Unix
+// time.
+func (s *Session) expired(now int64) bool {
+	if now-s.LastAccess > int64(idleTimeout()/time.Second) {
+		return true
+	}
+	return settings.SessionAbsoluteTimeout > 0 && now-s.CreatedAt > int64(settings.SessionAbsoluteTimeout/time.Second)
 }
 
 // Manager is the object to interact with sessions data. It allows to create, retrieve and delete sessions.
 type Manager struct {
-	activeSessionsMap map[string]*Session
-	commandsChan      chan *command
-	db                *sql.DB
+	store        Store
+	commandsChan chan *command
+	users        UserProvider
+	// set when sessions are kept in cookies
+	codec *cookieCodec
 }
 
 ///////////////////////////////////////////////////////////////// functions
@@ -77,22 +164,52 @@ func Initialize() {
 
 	security.Initialize()
 
+	if settings.RolesFile != "" {
+		if err := LoadRoles(settings.StartDir + settings.RolesFile); err != nil {
+			panic(err)
+		}
+	}
+
+	store, err := NewStoreFromSettings()
+	if err != nil {
+		panic(err)
+	}
+	users, err := NewUserProviderFromSettings()
+	if err != nil {
+		panic(err)
+	}
+
 	instance = &Manager{}
-	instance.initialize()
+	instance.initialize(store, users)
+	if settings.SessionMode == SessionModeCookie {
+		instance.codec, err = newCookieCodec(strings.Split(settings.SessionCookieKeys, ","), settings.SessionCookieEncrypt)
+		if err != nil {
+			panic(err)
+		}
+	}
 	go instance.commandProcessor()
 	go instance.periodicExpiredSessionsClean()
 	fmt.Println("Session manager started.")
 
-	if settings.RestoreSessions {
+	memoryStore, ok := store.(*MemoryStore)
+	if settings.RestoreSessions && ok && instance.codec == nil {
 		fileData, err := ioutil.ReadFile(sessionsFilePath)
 		if err != nil {
 			fmt.Println("Cannot restore sessions:", err)
 			return
 		}
-		err = json.Unmarshal(fileData, &(instance.activeSessionsMap))
+		sessions := make(map[string]*Session)
+		err = json.Unmarshal(fileData, &sessions)
 		if err != nil {
 			fmt.Println("Cannot unmarshal sessions:", err)
 		}
+		for _, v := range sessions {
+			// archived before creation time was tracked
+			if v.CreatedAt == 0 {
+				v.CreatedAt = v.LastAccess
+			}
+		}
+		memoryStore.Restore(sessions)
 	}
 }
 
@@ -102,12 +219,17 @@ func GetManager() *Manager {
 }
 
 // Deinit shuts down session management and if proper option was set it stores session data into a json file.
+// Only sessions kept in memory are archived, other stores are persistent on their own.
 func Deinit() {
 	if instance == nil {
 		fmt.Println("Session manager inactive, nothing to deinitialize.")
 		return
 	}
-	b, err := json.Marshal(instance.activeSessionsMap)
+	memoryStore, ok := instance.store.(*MemoryStore)
+	if !settings.ArchiveSessions || !ok || instance.codec != nil {
+		return
+	}
+	b, err := json.Marshal(memoryStore.Snapshot())
 	if err != nil {
 		fmt.Println("Cannot marshal sessions:", err)
 	}
@@ -118,78 +240,109 @@ func Deinit() {
 	}
 }
 
-// RoleOrHigher TODO
+// RoleOrHigher reports if level of userRole is at least level of role, see
+// DefineRole.
 func RoleOrHigher(userRole, role string) bool {
-	ur := roleIntValueMap[userRole]
-	r := roleIntValueMap[role]
-	return ur >= r
+	return roleLevel(userRole) >= roleLevel(role)
 }
 
-// RoleOrLower TODO
+// RoleOrLower reports if level of userRole is at most level of role.
 func RoleOrLower(userRole, role string) bool {
-	ur := roleIntValueMap[userRole]
-	r := roleIntValueMap[role]
-	return ur <= r
+	return roleLevel(userRole) <= roleLevel(role)
 }
 
 ///////////////////////////////////////////////////////////////// SessionManager methods
-func (s *Manager) initialize() {
-	s.activeSessionsMap = make(map[string]*Session)
+func (s *Manager) initialize(store Store, users UserProvider) {
+	s.store = store
+	s.users = users
 	s.commandsChan = make(chan *command)
-	var err error
-	s.db, err = database.ConnectToDb("user", "pass", "dbname")
-	if err != nil {
-		panic(err)
-	}
 }
 
-func generateSessionID(user string, r *http.Request) string {
-	addr := strings.Split(r.RemoteAddr, ":")
-	remoteIP := ""
+// writeCookie sends session cookie, which holds session id or whole session
+// when sessions are kept in cookies.
+func (s *Manager) writeCookie(w http.ResponseWriter, session *Session) error {
+	value := session.ID
+	if s.codec != nil {
+		var err error
+		if value, err = s.codec.encode(session); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(w, sessionCookie(value))
+	return nil
+}
 
-	if len(addr) > 0 {
-		remoteIP = addr[0]
+// cookieSession returns session held by cookie value if it's valid and not
+// expired. Renewed session is marked as modified, so the cookie is sent again.
+func (s *Manager) cookieSession(value string, r *http.Request) (*Session, error) {
+	session, err := s.codec.decode(value)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if session.expired(now) || session.RemoteAddr != remoteIP(r) {
+		return nil, errors.New("No session for cookie.")
 	}
+	if now-session.LastAccess >= renewInterval {
+		session.LastAccess = now
+		session.renewed = true
+		session.dirty = true
+	}
+	return session, nil
+}
 
-	h := sha256.New()
-	io.WriteString(h, user)
-	io.WriteString(h, r.UserAgent())
-	io.WriteString(h, remoteIP)
-	io.WriteString(h, security.GenerateRandomSalt())
-	sID := h.Sum(nil)
+func remoteIP(r *http.Request) string {
+	return strings.Split(r.RemoteAddr, ":")[0]
+}
 
-	return hex.EncodeToString(sID)
+// generateSessionID returns random session id, it's hex encoded so it can be
+// used as a file name by FileStore.
+func generateSessionID() string {
+	return security.GenerateTokenHex(security.DefaultTokenSize)
 }
 
 func (s *Manager) periodicExpiredSessionsClean() {
 	for {
-		time.Sleep(time.Minute * 10)
+		time.Sleep(cleanupInterval())
 		rchan := make(chan response)
 		s.commandsChan <- &command{respChan: rchan, code: cmdRemoveExpired}
-		<-rchan
+		resp := <-rchan
+		if resp.err != nil {
+			fmt.Println("Cannot remove expired sessions:", resp.err)
+		}
 	}
 }
 
-func (s *Manager) createSession(user string, r *http.Request) *Session {
-	sessionObject := &Session{LastAccess: time.Now().Unix()}
-	sessionID := generateSessionID(user, r)
-
-	sessionObject.ID = sessionID
-	sessionObject.UserName = user
-	sessionObject.RemoteAddr = strings.Split(r.RemoteAddr, ":")[0]
-	sessionObject.Agent = r.UserAgent()
+func newSession(r *http.Request) *Session {
+	now := time.Now().Unix()
+	return &Session{LastAccess: now, CreatedAt: now, RemoteAddr: remoteIP(r), Agent: r.UserAgent()}
+}
 
-	err := s.db.QueryRow("SELECT id FROM User WHERE login=?", sessionObject.UserName).Scan(&sessionObject.UserID)
+// createSession creates session for logged in user. Data of previous session
+// (e.g. anonymous one) is carried over, but the session gets fresh id.
+func (s *Manager) createSession(login string, r *http.Request, previous *Session) (*Session, error) {
+	user, err := s.users.LookupByLogin(login)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	s.db.QueryRow("SELECT Role.name FROM Role INNER JOIN UserRole ON UserRole.role_id=Role.id WHERE UserRole.user_id=?", sessionObject.UserID).Scan(&sessionObject.UserRole)
+	roles, err := s.users.RolesFor(user.ID)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return sessionObject
+	sessionObject := newSession(r)
+	sessionObject.ID = generateSessionID()
+	sessionObject.UserID = user.ID
+	sessionObject.UserName = user.Login
+	if len(roles) > 0 {
+		sessionObject.UserRole = roles[0]
+		sessionObject.UserRoles = roles
+	}
+	if previous != nil {
+		sessionObject.Data = copyData(previous.Data)
+	}
+
+	return sessionObject, nil
 }
 
 func (s *Manager) commandProcessor() {
@@ -197,89 +350,206 @@ func (s *Manager) commandProcessor() {
 		switch cmd.code {
 
 		case cmdGetSession:
-			sessionObject, ok := s.activeSessionsMap[cmd.sessionID]
-			ok = ok && sessionObject.RemoteAddr == strings.Split(cmd.r.RemoteAddr, ":")[0]
+			now := time.Now().Unix()
+			sessionObject, err := s.store.Get(cmd.sessionID)
+			if err == nil && sessionObject.expired(now) {
+				err = s.store.Delete(cmd.sessionID)
+				sessionObject = nil
+			}
+			ok := sessionObject != nil && sessionObject.RemoteAddr == remoteIP(cmd.r)
+			if ok && now-sessionObject.LastAccess >= renewInterval {
+				err = s.store.Touch(sessionObject.ID, now)
+				sessionObject.LastAccess = now
+				sessionObject.renewed = true
+			}
 			if ok {
-				cmd.respChan <- response{sessionObject, nil}
+				if err != nil {
+					fmt.Println("Cannot renew session:", err)
+				}
+				cmd.respChan <- response{session: sessionObject}
 			} else {
-				cmd.respChan <- response{nil, errors.New("No session for id: " + cmd.sessionID)}
+				if err != nil && err != ErrSessionNotFound {
+					fmt.Println("Cannot get session:", err)
+				}
+				cmd.respChan <- response{err: errors.New("No session for id: " + cmd.sessionID)}
 			}
 			break
 
 		case cmdCreateSession:
-			sessionObject := s.createSession(cmd.user, cmd.r)
-			s.activeSessionsMap[sessionObject.ID] = sessionObject
-			cmd.respChan <- response{sessionObject, nil}
+			sessionObject, err := s.createSession(cmd.user, cmd.r, cmd.session)
+			if err == nil {
+				err = s.store.Save(sessionObject)
+			}
+			if err == nil && cmd.session != nil && cmd.session.ID != "" {
+				err = s.store.Delete(cmd.session.ID)
+			}
+			if err == nil && settings.MaxSessionsPerUser > 0 && sessionObject.UserID != 0 {
+				err = s.evictOldest(sessionObject.UserID, settings.MaxSessionsPerUser)
+			}
+			cmd.respChan <- response{session: sessionObject, err: err}
 			break
 
 		case cmdRemoveExpired:
-			var toRemove []string
 			now := time.Now().Unix()
+			createdBefore := int64(0)
+			if settings.SessionAbsoluteTimeout > 0 {
+				createdBefore = now - int64(settings.SessionAbsoluteTimeout/time.Second)
+			}
+			err := s.store.GC(now-int64(idleTimeout()/time.Second), createdBefore)
+			cmd.respChan <- response{err: err}
+			break
 
-			for k, v := range s.activeSessionsMap {
-				if now-v.LastAccess > int64(time.Hour) {
-					toRemove = append(toRemove, k)
-				}
+		case cmdSaveSession:
+			if cmd.session.ID == "" {
+				cmd.session.ID = generateSessionID()
 			}
+			err := s.store.Save(cmd.session)
+			cmd.respChan <- response{session: cmd.session, err: err}
+			break
 
-			for _, v := range toRemove {
-				delete(s.activeSessionsMap, v)
+		case cmdRegenerateID:
+			previousID := cmd.session.ID
+			cmd.session.ID = generateSessionID()
+			err := s.store.Save(cmd.session)
+			if err == nil && previousID != "" {
+				err = s.store.Delete(previousID)
 			}
-			cmd.respChan <- response{}
+			cmd.respChan <- response{session: cmd.session, err: err}
 			break
 
 		case cmdExpireSession:
-			delete(s.activeSessionsMap, cmd.sessionID)
+			if err := s.store.Delete(cmd.sessionID); err != nil {
+				fmt.Println("Cannot expire session:", err)
+			}
 			break
 
 		case cmdExpireUserID:
-			var k string
-			var v *Session
-			found := false
-			for k, v = range s.activeSessionsMap {
-				if v.UserID == cmd.userID {
-					found = true
-					break
+			sessions, err := s.store.ListByUser(cmd.userID)
+			for i := 0; err == nil && i < len(sessions); i++ {
+				// empty sessionID means all sessions of the user
+				if cmd.sessionID == "" || cmd.sessionID == sessions[i].ID {
+					err = s.store.Delete(sessions[i].ID)
 				}
 			}
-			if found {
-				delete(s.activeSessionsMap, k)
+			if err != nil {
+				fmt.Println("Cannot expire session:", err)
 			}
 			break
+
+		case cmdListUser:
+			sessions, err := s.store.ListByUser(cmd.userID)
+			cmd.respChan <- response{sessions: sessions, err: err}
+			break
 		}
 	}
 	fmt.Println("commandProcessor finished!")
 }
 
-// ExpireSessionByUserID TODO
+// evictOldest removes least recently used sessions of user until at most max
+// sessions are left.
+func (s *Manager) evictOldest(userID, max int) error {
+	sessions, err := s.store.ListByUser(userID)
+	if err != nil || len(sessions) <= max {
+		return err
+	}
+	sortByLastAccess(sessions)
+	for _, v := range sessions[max:] {
+		if err := s.store.Delete(v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortByLastAccess sorts sessions starting from most recently used one.
+func sortByLastAccess(sessions []*Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].LastAccess == sessions[j].LastAccess {
+			return sessions[i].CreatedAt > sessions[j].CreatedAt
+		}
+		return sessions[i].LastAccess > sessions[j].LastAccess
+	})
+}
+
+// ExpireSessionByUserID expires all sessions of user with given id, e.g. to
+// log user out everywhere.
 func (s *Manager) ExpireSessionByUserID(userID int) {
-	s.commandsChan <- &command{make(chan response), cmdExpireUserID, strconv.Itoa(userID), nil, "", userID}
-	fmt.Println("session for user with id:", userID, "- expired")
+	if s.codec != nil {
+		fmt.Println("Cannot expire sessions:", ErrCookieSessions)
+		return
+	}
+	s.commandsChan <- &command{respChan: make(chan response), code: cmdExpireUserID, userID: userID}
+	fmt.Println("sessions for user with id:", userID, "- expired")
+}
+
+// ExpireUserSession expires single session of user with given id. Session is
+// not expired if it belongs to another user, so id can come from a request.
+func (s *Manager) ExpireUserSession(userID int, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	if s.codec != nil {
+		fmt.Println("Cannot expire session:", ErrCookieSessions)
+		return
+	}
+	s.commandsChan <- &command{respChan: make(chan response), code: cmdExpireUserID, sessionID: sessionID, userID: userID}
+	fmt.Println("session:", sessionID, " expired")
+}
+
+// UserSessions returns active sessions of user with given id, most recently
+// used first. Agent, RemoteAddr and LastAccess can be presented to user, e.g.
+// on account page.
+func (s *Manager) UserSessions(userID int) ([]*Session, error) {
+	if s.codec != nil {
+		return nil, ErrCookieSessions
+	}
+	respChan := make(chan response)
+	s.commandsChan <- &command{respChan: respChan, code: cmdListUser, userID: userID}
+	resp := <-respChan
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	now := time.Now().Unix()
+	sessions := make([]*Session, 0, len(resp.sessions))
+	for _, v := range resp.sessions {
+		if !v.expired(now) {
+			sessions = append(sessions, v)
+		}
+	}
+	sortByLastAccess(sessions)
+	return sessions, nil
 }
 
 // ExpireSession TODO
 func (s *Manager) ExpireSession(params map[string]interface{}) {
 	w := params["__writer"].(http.ResponseWriter)
-	currentSession := params["session"].(*Session)
-	if currentSession != nil {
-		s.commandsChan <- &command{make(chan response), cmdExpireSession, currentSession.ID, nil, "", 0}
+	currentSession, _ := params["session"].(*Session)
+	if currentSession != nil && currentSession.ID != "" && s.codec == nil {
+		s.commandsChan <- &command{respChan: make(chan response), code: cmdExpireSession, sessionID: currentSession.ID}
 		fmt.Println("session:", currentSession.ID, " expired")
 	}
-	expiredCookie := &http.Cookie{Name: "data", Value: "", Expires: time.Unix(0, 0)}
-	http.SetCookie(w, expiredCookie)
+	http.SetCookie(w, expiredSessionCookie())
 }
 
 // GetSession TODO
 func (s *Manager) GetSession(request *http.Request) *Session {
-	c, err := request.Cookie("data")
+	c, err := request.Cookie(settings.SessionCookieName)
 
 	if err != nil || c == nil || c.Value == "" {
 		return nil
 	}
 
+	if s.codec != nil {
+		session, err := s.cookieSession(c.Value, request)
+		if err != nil {
+			fmt.Println("Cannot get session from cookie:", err)
+		}
+		return session
+	}
+
 	sessionID := c.Value
 	respChan := make(chan response)
-	s.commandsChan <- &command{respChan, cmdGetSession, sessionID, request, "", 0}
+	s.commandsChan <- &command{respChan: respChan, code: cmdGetSession, sessionID: sessionID, r: request}
 	resp := <-respChan
 	if resp.session != nil {
 		fmt.Println("session:", sessionID, " exists")
@@ -289,18 +559,125 @@ func (s *Manager) GetSession(request *http.Request) *Session {
 	return resp.session
 }
 
-// CreateSession TODO
-func (s *Manager) CreateSession(params map[string]interface{}) *Session {
+// CreateSession creates session for user with login given in params["login"].
+// If there is current session in params["session"] (e.g. anonymous one) its
+// data is carried over to the new session and the old one is removed, so
+// session id always changes on login. New session replaces params["session"].
+// User is looked up using manager's UserProvider, ErrUserNotFound is returned
+// if there is no user with given login.
+func (s *Manager) CreateSession(params map[string]interface{}) (*Session, error) {
 	w := params["__writer"].(http.ResponseWriter)
 	r := params["request"].(*http.Request)
+	previous, _ := params["session"].(*Session)
 
-	respChan := make(chan response)
-	s.commandsChan <- &command{respChan: respChan, code: cmdCreateSession, r: r, user: params["login"].(string)}
-	resp := <-respChan
+	var resp response
+	if s.codec != nil {
+		resp.session, resp.err = s.createSession(params["login"].(string), r, previous)
+	} else {
+		respChan := make(chan response)
+		s.commandsChan <- &command{respChan: respChan, code: cmdCreateSession, r: r, user: params["login"].(string), session: previous}
+		resp = <-respChan
+	}
+	if resp.err == nil {
+		resp.err = s.writeCookie(w, resp.session)
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	}
 	fmt.Println("session:", resp.session.ID, " created")
 
-	sessionCookie := &http.Cookie{Name: "data", Value: resp.session.ID}
-	http.SetCookie(w, sessionCookie)
+	params["session"] = resp.session
 
-	return resp.session
+	return resp.session, nil
+}
+
+// TransientSession returns session of user with given login which is valid only
+// for current request. It isn't stored and no cookie is sent, so it's meant for
+// requests authenticated on their own, e.g. with API keys.
+func (s *Manager) TransientSession(login string, r *http.Request) (*Session, error) {
+	session, err := s.createSession(login, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = ""
+	session.transient = true
+	return session, nil
+}
+
+// SetUserProvider replaces user provider created from settings. It should be
+// called after Initialize but before requests are served.
+func (s *Manager) SetUserProvider(users UserProvider) {
+	s.users = users
+}
+
+// NewSession returns anonymous session for given request. Session is not
+// stored until some data is set in it and SaveSession is called.
+func (s *Manager) NewSession(r *http.Request) *Session {
+	return newSession(r)
+}
+
+// RegenerateID gives current session (params["session"]) new id and sends
+// new cookie. It should be called on every privilege change to prevent
+// session fixation.
+func (s *Manager) RegenerateID(params map[string]interface{}) error {
+	w := params["__writer"].(http.ResponseWriter)
+	currentSession, _ := params["session"].(*Session)
+	if currentSession == nil {
+		return nil
+	}
+
+	if s.codec != nil {
+		currentSession.ID = generateSessionID()
+	} else {
+		respChan := make(chan response)
+		s.commandsChan <- &command{respChan: respChan, code: cmdRegenerateID, session: currentSession}
+		resp := <-respChan
+		if resp.err != nil {
+			return resp.err
+		}
+	}
+	currentSession.dirty = false
+	return s.writeCookie(w, currentSession)
+}
+
+// RefreshCookie sends session cookie again if it's persistent (see
+// -session-cookie-max-age setting) and session was renewed, so that cookie
+// expires together with the session.
+func (s *Manager) RefreshCookie(w http.ResponseWriter, session *Session) {
+	// cookie sessions are sent again by SaveSession
+	if session != nil && session.renewed && settings.SessionCookieMaxAge > 0 && s.codec == nil {
+		http.SetCookie(w, sessionCookie(session.ID))
+	}
+}
+
+// SaveSession stores session if its data was modified during current request.
+// Anonymous session stored for the first time gets an id which is sent in
+// session cookie. Cookie sessions are stored by sending the cookie again, so
+// SaveSession has to be called before response is written.
+func (s *Manager) SaveSession(w http.ResponseWriter, session *Session) error {
+	if session == nil || !session.dirty || session.transient {
+		return nil
+	}
+	if s.codec != nil {
+		if session.ID == "" {
+			session.ID = generateSessionID()
+		}
+		if err := s.writeCookie(w, session); err != nil {
+			return err
+		}
+		session.dirty = false
+		return nil
+	}
+	created := session.ID == ""
+	respChan := make(chan response)
+	s.commandsChan <- &command{respChan: respChan, code: cmdSaveSession, session: session}
+	resp := <-respChan
+	if resp.err != nil {
+		return resp.err
+	}
+	session.dirty = false
+	if created {
+		return s.writeCookie(w, session)
+	}
+	return nil
 }
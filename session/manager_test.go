@@ -0,0 +1,192 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/solgar/upendo/settings"
+)
+
+func TestSessionExpired(t *testing.T) {
+	_t = t
+	settings.SessionIdleTimeout = time.Hour
+	settings.SessionAbsoluteTimeout = 24 * time.Hour
+
+	now := time.Now().Unix()
+	s := &Session{LastAccess: now - 60, CreatedAt: now - 60}
+	assert(!s.expired(now), "Recently used session should not expire.")
+
+	s.LastAccess = now - 2*3600
+	assert(s.expired(now), "Idle session should expire.")
+
+	s.LastAccess = now
+	s.CreatedAt = now - 25*3600
+	assert(s.expired(now), "Session should expire after absolute timeout.")
+
+	settings.SessionAbsoluteTimeout = 0
+	assert(!s.expired(now), "Absolute timeout should be disabled.")
+
+	settings.SessionIdleTimeout, settings.SessionCleanupInterval = 0, -time.Second
+	assert(!s.expired(now) && cleanupInterval() == defaultCleanupInterval, "Unset timeouts should fall back to defaults.")
+	settings.SessionIdleTimeout = time.Hour
+}
+
+func TestSessionCookie(t *testing.T) {
+	_t = t
+	settings.SessionCookieName = "sid"
+	settings.SessionCookiePath = "/"
+	settings.SessionCookieHTTPOnly = true
+	settings.SessionCookieSameSite = "Strict"
+	settings.SessionCookieMaxAge = time.Hour
+
+	c := sessionCookie("abc")
+	assert(c.Name == "sid" && c.Value == "abc", "Wrong cookie name or value.")
+	assert(c.HttpOnly && c.SameSite == http.SameSiteStrictMode, "Wrong cookie attributes.")
+	assert(c.MaxAge == 3600, "Wrong Max-Age.")
+
+	c = expiredSessionCookie()
+	assert(c.MaxAge < 0 && c.Path == "/", "Expired cookie should match path and have negative Max-Age.")
+}
+
+func startTestManager() *Manager {
+	m := &Manager{}
+	users := NewMemoryUserProvider()
+	users.AddUser(7, "alice", "admin", "user")
+	m.initialize(NewMemoryStore(), users)
+	go m.commandProcessor()
+	return m
+}
+
+func TestAnonymousSession(t *testing.T) {
+	_t = t
+	settings.SessionIdleTimeout = time.Hour
+	settings.SessionCookieName = "data"
+	m := startTestManager()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	s := m.NewSession(r)
+	assert(s.Anonymous() && s.ID == "", "New session should be anonymous and have no id.")
+
+	assert(m.SaveSession(w, s) == nil && s.ID == "", "Unmodified session should not be stored.")
+	assert(len(w.Result().Cookies()) == 0, "Cookie should not be sent for unmodified session.")
+
+	s.Set("theme", "dark")
+	w = httptest.NewRecorder()
+	assert(m.SaveSession(w, s) == nil && s.ID != "", "Modified session should be stored.")
+	cookies := w.Result().Cookies()
+	assert(len(cookies) == 1 && cookies[0].Value == s.ID, "Session cookie should be sent.")
+
+	r.AddCookie(&http.Cookie{Name: "data", Value: s.ID})
+	loaded := m.GetSession(r)
+	assert(loaded != nil && loaded.Value("theme") == "dark", "Stored session should be loaded.")
+
+	previousID := loaded.ID
+	params := map[string]interface{}{"__writer": httptest.NewRecorder(), "session": loaded}
+	assert(m.RegenerateID(params) == nil, "RegenerateID should succeed.")
+	assert(loaded.ID != previousID, "Session id should change.")
+
+	_, err := m.store.Get(previousID)
+	assert(err == ErrSessionNotFound, "Session should not be available under previous id.")
+	moved, err := m.store.Get(loaded.ID)
+	assert(err == nil && moved.Value("theme") == "dark", "Session data should be kept under new id.")
+}
+
+func TestAnonymousSessionsNotLimited(t *testing.T) {
+	_t = t
+	settings.SessionIdleTimeout = time.Hour
+	settings.MaxSessionsPerUser = 1
+	defer func() { settings.MaxSessionsPerUser = 0 }()
+	m := startTestManager()
+
+	visitors := make([]*Session, 3)
+	for i := range visitors {
+		visitors[i] = m.NewSession(httptest.NewRequest("GET", "/", nil))
+		visitors[i].Set("cart", i)
+		assert(m.SaveSession(httptest.NewRecorder(), visitors[i]) == nil, "Anonymous session should be stored.")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := m.CreateSession(map[string]interface{}{"__writer": httptest.NewRecorder(), "request": r, "login": "alice"})
+	assert(err == nil, "CreateSession should succeed.")
+
+	for _, v := range visitors {
+		_, err := m.store.Get(v.ID)
+		assert(err == nil, "Anonymous sessions should not be evicted.")
+	}
+	sessions, _ := m.store.ListByUser(0)
+	assert(len(sessions) == 0, "Anonymous sessions should not be indexed.")
+}
+
+func TestCreateSessionCarriesOverData(t *testing.T) {
+	_t = t
+	settings.SessionIdleTimeout = time.Hour
+	m := startTestManager()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	anonymous := m.NewSession(r)
+	anonymous.Set("cart", []string{"apple"})
+	m.SaveSession(httptest.NewRecorder(), anonymous)
+
+	params := map[string]interface{}{"__writer": httptest.NewRecorder(), "request": r, "session": anonymous, "login": "alice"}
+	s, err := m.CreateSession(params)
+	assert(err == nil, "CreateSession should succeed.")
+	assert(s.UserID == 7 && s.UserName == "alice" && s.UserRole == "admin", "User data should come from provider.")
+	assert(s.ID != anonymous.ID && params["session"] == s, "New session should replace anonymous one.")
+	assert(s.Has("cart"), "Anonymous session data should be carried over.")
+
+	_, err = m.store.Get(anonymous.ID)
+	assert(err == ErrSessionNotFound, "Anonymous session should be removed.")
+
+	params["login"] = "mallory"
+	_, err = m.CreateSession(params)
+	assert(err == ErrUserNotFound, "ErrUserNotFound expected for unknown login.")
+}
+
+func TestUserSessions(t *testing.T) {
+	_t = t
+	settings.SessionIdleTimeout = time.Hour
+	settings.MaxSessionsPerUser = 2
+	defer func() { settings.MaxSessionsPerUser = 0 }()
+	m := startTestManager()
+
+	login := func(agent string) *Session {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", agent)
+		params := map[string]interface{}{"__writer": httptest.NewRecorder(), "request": r, "login": "alice"}
+		s, err := m.CreateSession(params)
+		assert(err == nil, "CreateSession should succeed.")
+		return s
+	}
+
+	phone := login("phone")
+	laptop := login("laptop")
+	m.store.Touch(phone.ID, time.Now().Unix()+10)
+	m.store.Touch(laptop.ID, time.Now().Unix()-10)
+
+	sessions, err := m.UserSessions(7)
+	assert(err == nil && len(sessions) == 2, "User should have two sessions.")
+	assert(sessions[0].ID == phone.ID, "Most recently used session should be first.")
+
+	tablet := login("tablet")
+	sessions, _ = m.UserSessions(7)
+	assert(len(sessions) == 2, "Number of sessions should be limited.")
+	for _, v := range sessions {
+		assert(v.ID != laptop.ID, "Least recently used session should be evicted.")
+	}
+
+	m.ExpireUserSession(8, tablet.ID)
+	sessions, _ = m.UserSessions(7)
+	assert(len(sessions) == 2, "Session of other user should not be expired.")
+
+	m.ExpireUserSession(7, tablet.ID)
+	sessions, _ = m.UserSessions(7)
+	assert(len(sessions) == 1 && sessions[0].ID == phone.ID, "Single session should be expired.")
+
+	login("laptop")
+	m.ExpireSessionByUserID(7)
+	sessions, _ = m.UserSessions(7)
+	assert(len(sessions) == 0, "All sessions of user should be expired.")
+}
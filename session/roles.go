@@ -0,0 +1,201 @@
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+)
+
+// RoleAnonymous is the role of visitors who didn't log in.
+const RoleAnonymous = "anon"
+
+var (
+	rolesMutex sync.RWMutex
+	roles      = map[string]*role{}
+)
+
+type role struct {
+	level       int
+	inherits    []string
+	permissions map[string]bool
+}
+
+// RoleDefinition describes role in roles file (see -roles-file setting):
+//
+//	{"editor": {"Level": 3, "Inherits": ["user"], "Permissions": ["post.edit"]}}
+type RoleDefinition struct {
+	Level       int
+	Inherits    []string
+	Permissions []string
+}
+
+func init() {
+	ResetRoles()
+}
+
+// ResetRoles restores default roles: anon < user < admin < root, each one
+// inherits permissions of the previous one.
+func ResetRoles() {
+	rolesMutex.Lock()
+	roles = map[string]*role{}
+	rolesMutex.Unlock()
+
+	DefineRole(RoleAnonymous, 1)
+	DefineRole("user", 2, RoleAnonymous)
+	DefineRole("admin", 3, "user")
+	DefineRole("root", 4, "admin")
+}
+
+// DefineRole adds or replaces role. Level is used by RoleOrHigher and
+// RoleOrLower, permissions are inherited from roles given in inherits.
+// Permissions already granted to the role are kept.
+func DefineRole(name string, level int, inherits ...string) {
+	rolesMutex.Lock()
+	defer rolesMutex.Unlock()
+	r, ok := roles[name]
+	if !ok {
+		r = &role{permissions: make(map[string]bool)}
+		roles[name] = r
+	}
+	r.level = level
+	r.inherits = append([]string(nil), inherits...)
+}
+
+// GrantPermission grants permissions to role, role is defined with level 0 if
+// it doesn't exist.
+func GrantPermission(roleName string, permissions ...string) {
+	rolesMutex.Lock()
+	defer rolesMutex.Unlock()
+	r, ok := roles[roleName]
+	if !ok {
+		r = &role{permissions: make(map[string]bool)}
+		roles[roleName] = r
+	}
+	for _, p := range permissions {
+		r.permissions[p] = true
+	}
+}
+
+// RevokePermission revokes permissions granted directly to role.
+func RevokePermission(roleName string, permissions ...string) {
+	rolesMutex.Lock()
+	defer rolesMutex.Unlock()
+	if r, ok := roles[roleName]; ok {
+		for _, p := range permissions {
+			delete(r.permissions, p)
+		}
+	}
+}
+
+// LoadRoles defines roles described in JSON file, see RoleDefinition.
+func LoadRoles(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	definitions := make(map[string]RoleDefinition)
+	if err := json.Unmarshal(b, &definitions); err != nil {
+		return err
+	}
+	for name, d := range definitions {
+		DefineRole(name, d.Level, d.Inherits...)
+		GrantPermission(name, d.Permissions...)
+	}
+	return nil
+}
+
+func roleLevel(name string) int {
+	rolesMutex.RLock()
+	defer rolesMutex.RUnlock()
+	if r, ok := roles[name]; ok {
+		return r.level
+	}
+	return 0
+}
+
+// RoleIncludes reports if role is given one or inherits from it.
+func RoleIncludes(roleName, included string) bool {
+	rolesMutex.RLock()
+	defer rolesMutex.RUnlock()
+	return walkRoles(roleName, map[string]bool{}, func(name string, _ *role) bool {
+		return name == included
+	})
+}
+
+// RoleHasPermission reports if permission is granted to role directly or
+// through inherited roles.
+func RoleHasPermission(roleName, permission string) bool {
+	rolesMutex.RLock()
+	defer rolesMutex.RUnlock()
+	return walkRoles(roleName, map[string]bool{}, func(_ string, r *role) bool {
+		return r.permissions[permission]
+	})
+}
+
+// walkRoles calls f for role and roles it inherits until f returns true,
+// mutex has to be locked.
+func walkRoles(name string, visited map[string]bool, f func(string, *role) bool) bool {
+	r, ok := roles[name]
+	if !ok || visited[name] {
+		return false
+	}
+	visited[name] = true
+	if f(name, r) {
+		return true
+	}
+	for _, parent := range r.inherits {
+		if walkRoles(parent, visited, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles returns roles of session user. Anonymous sessions (and nil session)
+// and sessions waiting for second factor have only RoleAnonymous.
+func (s *Session) Roles() []string {
+	if s.Anonymous() || s.TwoFactorPending() {
+		return []string{RoleAnonymous}
+	}
+	if len(s.UserRoles) > 0 {
+		return s.UserRoles
+	}
+	if s.UserRole != "" {
+		// session created before multiple roles were kept
+		return []string{s.UserRole}
+	}
+	return nil
+}
+
+// HasRole reports if any role of session user is given one or inherits from
+// it.
+func (s *Session) HasRole(role string) bool {
+	for _, r := range s.Roles() {
+		if RoleIncludes(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports if any role of session user has given permission.
+func (s *Session) Can(permission string) bool {
+	for _, r := range s.Roles() {
+		if RoleHasPermission(r, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports if session user has given permission, it's meant for templates:
+//
+//	{{if can .session "post.edit"}}...{{end}}
+func Can(s *Session, permission string) bool {
+	return s.Can(permission)
+}
+
+// HasRole reports if session user has given role, it's meant for templates.
+func HasRole(s *Session, role string) bool {
+	return s.HasRole(role)
+}
@@ -0,0 +1,33 @@
+package session
+
+import (
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	_t = t
+	defer ResetRoles()
+	DefineRole("editor", 3, "user")
+	GrantPermission("user", "comment.add")
+	GrantPermission("editor", "post.edit")
+
+	assert(RoleOrHigher("admin", "user") && !RoleOrHigher("user", "admin"), "Default ladder should be kept.")
+	assert(RoleOrHigher("editor", "admin") && RoleOrLower("editor", "admin"), "Configured level should be used.")
+	assert(RoleHasPermission("editor", "comment.add"), "Permission should be inherited.")
+	assert(!RoleHasPermission("user", "post.edit"), "Permission should not be inherited downwards.")
+
+	s := &Session{UserName: "alice", UserRoles: []string{"user", "editor"}}
+	assert(s.Can("post.edit") && s.HasRole("user") && !s.HasRole("admin"), "Any role of user should count.")
+	RevokePermission("editor", "post.edit")
+	assert(!s.Can("post.edit"), "Revoked permission should not be granted.")
+
+	var anonymous *Session
+	assert(!Can(anonymous, "comment.add") && HasRole(anonymous, RoleAnonymous), "Anonymous session should have only anon role.")
+	legacy := &Session{UserName: "bob", UserRole: "admin"}
+	assert(legacy.Can("comment.add"), "Session with single role should be supported.")
+
+	legacy.SetTwoFactorPending(true)
+	assert(legacy.TwoFactorPending() && !legacy.Can("comment.add") && !legacy.HasRole("user"), "Session waiting for second factor should have no roles.")
+	legacy.SetTwoFactorPending(false)
+	assert(!legacy.TwoFactorPending() && legacy.HasRole("admin"), "Confirmed session should have its roles.")
+}
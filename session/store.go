@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/solgar/upendo/database"
+	"github.com/solgar/upendo/settings"
+)
+
+// Names of session stores which can be selected using -session-store setting.
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreSQL    = "sql"
+	StoreRedis  = "redis"
+)
+
+var (
+	// ErrSessionNotFound is returned by stores when there is no session with
+	// given id.
+	ErrSessionNotFound = errors.New("Session not found.")
+)
+
+// Store is the interface implemented by session storage backends. Manager
+// serializes calls to a store, but stores shared between several upendo
+// instances must handle concurrent access on their own.
+type Store interface {
+	// Get returns session with given id or ErrSessionNotFound.
+	Get(id string) (*Session, error)
+	// Save creates or replaces session.
+	Save(s *Session) error
+	// Delete removes session, removing missing session is not an error.
+	Delete(id string) error
+	// Touch updates LastAccess of session with given id.
+	Touch(id string, lastAccess int64) error
+	// GC removes sessions last accessed before lastAccessBefore or created
+	// before createdBefore (Unix time).
+	GC(lastAccessBefore, createdBefore int64) error
+	// ListByUser returns all sessions of user with given id. Anonymous
+	// sessions (user id 0) don't belong to any user, none is returned for 0.
+	ListByUser(userID int) ([]*Session, error)
+}
+
+// NewStoreFromSettings creates store selected with -session-store setting.
+func NewStoreFromSettings() (Store, error) {
+	switch settings.SessionStore {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreFile:
+		return NewFileStore(settings.StartDir + settings.SessionStoreAddr)
+	case StoreSQL:
+		db, err := database.Open(settings.SessionStoreDriver, settings.SessionStoreAddr)
+		if err != nil {
+			return nil, err
+		}
+		s := NewSQLStore(db, settings.SessionStoreTable)
+		return s, s.CreateTable()
+	case StoreRedis:
+		return NewRedisStore(settings.SessionStoreAddr, idleTimeout()), nil
+	}
+	return nil, errors.New("Unknown session store: " + settings.SessionStore)
+}
+
+// copySession returns copy of session so that values kept by a store are not
+// modified by its users.
+func copySession(s *Session) *Session {
+	c := *s
+	c.Data = copyData(s.Data)
+	c.UserRoles = append([]string(nil), s.UserRoles...)
+	c.renewed = false
+	c.dirty = false
+	return &c
+}
@@ -0,0 +1,154 @@
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sessionFileExt = ".json"
+)
+
+// FileStore keeps each session in separate JSON file inside a directory. Many
+// upendo instances can share the directory, e.g. on network file system.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates FileStore using given directory, directory is created
+// if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("Session store directory not set.")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir}, nil
+}
+
+// path returns path of file for session with given id. Ids are hex encoded
+// hashes, anything else could be used to access files outside of the store.
+func (f *FileStore) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", errors.New("Invalid session id: " + id)
+	}
+	return filepath.Join(f.dir, id+sessionFileExt), nil
+}
+
+func (f *FileStore) Get(id string) (*Session, error) {
+	p, err := f.path(id)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	return readSessionFile(p)
+}
+
+func (f *FileStore) Save(s *Session) error {
+	p, err := f.path(s.ID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// write to temporary file and rename it so readers never see partial data
+	tmp, err := ioutil.TempFile(f.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *FileStore) Delete(id string) error {
+	p, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileStore) Touch(id string, lastAccess int64) error {
+	s, err := f.Get(id)
+	if err != nil {
+		return err
+	}
+	s.LastAccess = lastAccess
+	return f.Save(s)
+}
+
+func (f *FileStore) GC(lastAccessBefore, createdBefore int64) error {
+	return f.each(func(p string, s *Session) {
+		if s.LastAccess < lastAccessBefore || s.CreatedAt < createdBefore {
+			os.Remove(p)
+		}
+	})
+}
+
+func (f *FileStore) ListByUser(userID int) ([]*Session, error) {
+	result := make([]*Session, 0)
+	if userID == 0 {
+		return result, nil
+	}
+	err := f.each(func(p string, s *Session) {
+		if s.UserID == userID {
+			result = append(result, s)
+		}
+	})
+	return result, err
+}
+
+// each calls function for every readable session file in the store.
+func (f *FileStore) each(fn func(string, *Session)) error {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), sessionFileExt) {
+			continue
+		}
+		p := filepath.Join(f.dir, e.Name())
+		s, err := readSessionFile(p)
+		if err != nil {
+			continue
+		}
+		fn(p, s)
+	}
+	return nil
+}
+
+func readSessionFile(p string) (*Session, error) {
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"sync"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost when process
+// terminates unless they are archived (see -archive-sessions setting).
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+	// session ids indexed by user id, anonymous sessions aren't indexed
+	byUser map[int]map[string]bool
+}
+
+// NewMemoryStore creates empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*Session), byUser: make(map[int]map[string]bool)}
+}
+
+func (m *MemoryStore) Get(id string) (*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return copySession(s), nil
+}
+
+func (m *MemoryStore) Save(s *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.put(copySession(s))
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.remove(id)
+	return nil
+}
+
+func (m *MemoryStore) Touch(id string, lastAccess int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.LastAccess = lastAccess
+	return nil
+}
+
+func (m *MemoryStore) GC(lastAccessBefore, createdBefore int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for k, v := range m.sessions {
+		if v.LastAccess < lastAccessBefore || v.CreatedAt < createdBefore {
+			m.remove(k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListByUser(userID int) ([]*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make([]*Session, 0, len(m.byUser[userID]))
+	for id := range m.byUser[userID] {
+		result = append(result, copySession(m.sessions[id]))
+	}
+	return result, nil
+}
+
+// Snapshot returns copy of all stored sessions, it's used to archive sessions.
+func (m *MemoryStore) Snapshot() map[string]*Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make(map[string]*Session, len(m.sessions))
+	for k, v := range m.sessions {
+		result[k] = copySession(v)
+	}
+	return result
+}
+
+// Restore adds given sessions to the store, it's used to restore archived
+// sessions.
+func (m *MemoryStore) Restore(sessions map[string]*Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, v := range sessions {
+		m.put(copySession(v))
+	}
+}
+
+// put adds session to the store and user index, mutex has to be locked.
+func (m *MemoryStore) put(s *Session) {
+	m.remove(s.ID)
+	m.sessions[s.ID] = s
+	if s.UserID == 0 {
+		return
+	}
+	ids, ok := m.byUser[s.UserID]
+	if !ok {
+		ids = make(map[string]bool)
+		m.byUser[s.UserID] = ids
+	}
+	ids[s.ID] = true
+}
+
+// remove deletes session from the store and user index, mutex has to be
+// locked.
+func (m *MemoryStore) remove(id string) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return
+	}
+	delete(m.sessions, id)
+	if s.UserID == 0 {
+		return
+	}
+	delete(m.byUser[s.UserID], id)
+	if len(m.byUser[s.UserID]) == 0 {
+		delete(m.byUser, s.UserID)
+	}
+}
@@ -0,0 +1,229 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisKeyPrefix     = "upendo:session:"
+	redisUserKeyPrefix = "upendo:user:"
+	redisDialTimeout   = 5 * time.Second
+	// limit of single command, so server which stopped responding doesn't
+	// block session manager
+	redisCommandTimeout = 5 * time.Second
+)
+
+// RedisStore keeps sessions in a server speaking Redis protocol (RESP). Keys
+// expire on their own after ttl since last access, so GC doesn't do anything.
+type RedisStore struct {
+	conn *redisConn
+	ttl  time.Duration
+}
+
+// NewRedisStore creates RedisStore using server at given address. Connection
+// is established on first use and reestablished after errors.
+func NewRedisStore(addr string, ttl time.Duration) *RedisStore {
+	return &RedisStore{&redisConn{addr: addr, timeout: redisCommandTimeout}, ttl}
+}
+
+func (r *RedisStore) Get(id string) (*Session, error) {
+	reply, err := r.conn.do("GET", redisKeyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrSessionNotFound
+	}
+	return unmarshalSession(string(reply.([]byte)))
+}
+
+func (r *RedisStore) Save(s *Session) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := strconv.FormatInt(int64(r.ttl/time.Millisecond), 10)
+	if _, err := r.conn.do("SET", redisKeyPrefix+s.ID, string(b), "PX", ttl); err != nil || s.UserID == 0 {
+		return err
+	}
+	userKey := redisUserKeyPrefix + strconv.Itoa(s.UserID)
+	_, err = r.conn.do("SADD", userKey, s.ID)
+	return err
+}
+
+func (r *RedisStore) Delete(id string) error {
+	s, err := r.Get(id)
+	if err == ErrSessionNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := r.conn.do("DEL", redisKeyPrefix+id); err != nil || s.UserID == 0 {
+		return err
+	}
+	_, err = r.conn.do("SREM", redisUserKeyPrefix+strconv.Itoa(s.UserID), id)
+	return err
+}
+
+func (r *RedisStore) Touch(id string, lastAccess int64) error {
+	s, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	s.LastAccess = lastAccess
+	return r.Save(s)
+}
+
+func (r *RedisStore) GC(lastAccessBefore, createdBefore int64) error {
+	return nil
+}
+
+func (r *RedisStore) ListByUser(userID int) ([]*Session, error) {
+	if userID == 0 {
+		return []*Session{}, nil
+	}
+	userKey := redisUserKeyPrefix + strconv.Itoa(userID)
+	reply, err := r.conn.do("SMEMBERS", userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Session, 0)
+	members, _ := reply.([]interface{})
+	for _, m := range members {
+		id := string(m.([]byte))
+		s, err := r.Get(id)
+		if err == ErrSessionNotFound {
+			// session key expired, forget it
+			r.conn.do("SREM", userKey, id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if s.UserID == userID {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// redisConn is minimal client of Redis serialization protocol. Commands are
+// executed one at a time over single connection.
+type redisConn struct {
+	mutex   sync.Mutex
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+// do sends command and returns its reply: nil, string, int64, []byte or
+// []interface{} of those.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, redisDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+	}
+
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	var reply interface{}
+	if err == nil {
+		reply, err = c.roundTrip(args)
+	}
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			// connection state is unknown, start over next time
+			c.conn.Close()
+			c.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *redisConn) roundTrip(args []string) (interface{}, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis error: " + string(e)
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("Invalid redis reply: " + line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		result := make([]interface{}, n)
+		for i := range result {
+			if result[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return nil, errors.New("Invalid redis reply: " + line)
+}
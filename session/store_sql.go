@@ -0,0 +1,118 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// SQLStore keeps sessions in a database table. Session is stored as JSON
+// together with columns needed for lookups and expiration. Queries use "?"
+// placeholders (MySQL, SQLite).
+type SQLStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLStore creates SQLStore using given database and table.
+func NewSQLStore(db *sql.DB, table string) *SQLStore {
+	if table == "" {
+		table = "Session"
+	}
+	return &SQLStore{db, table}
+}
+
+// CreateTable creates sessions table if it doesn't exist.
+func (s *SQLStore) CreateTable() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + s.table + " (" +
+		"id VARCHAR(64) NOT NULL PRIMARY KEY, " +
+		"user_id INTEGER NOT NULL, " +
+		"last_access BIGINT NOT NULL, " +
+		"created_at BIGINT NOT NULL, " +
+		"data TEXT NOT NULL)")
+	return err
+}
+
+func (s *SQLStore) Get(id string) (*Session, error) {
+	var data string
+	err := s.db.QueryRow("SELECT data FROM "+s.table+" WHERE id=?", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return unmarshalSession(data)
+}
+
+func (s *SQLStore) Save(session *Session) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table+" WHERE id=?", session.ID)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO "+s.table+" (id, user_id, last_access, created_at, data) VALUES (?, ?, ?, ?, ?)",
+			session.ID, session.UserID, session.LastAccess, session.CreatedAt, string(b))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Delete(id string) error {
+	_, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id=?", id)
+	return err
+}
+
+func (s *SQLStore) Touch(id string, lastAccess int64) error {
+	session, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	session.LastAccess = lastAccess
+	return s.Save(session)
+}
+
+func (s *SQLStore) GC(lastAccessBefore, createdBefore int64) error {
+	_, err := s.db.Exec("DELETE FROM "+s.table+" WHERE last_access<? OR created_at<?", lastAccessBefore, createdBefore)
+	return err
+}
+
+func (s *SQLStore) ListByUser(userID int) ([]*Session, error) {
+	if userID == 0 {
+		return []*Session{}, nil
+	}
+	rows, err := s.db.Query("SELECT data FROM "+s.table+" WHERE user_id=?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Session, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		session, err := unmarshalSession(data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	return result, rows.Err()
+}
+
+func unmarshalSession(data string) (*Session, error) {
+	session := &Session{}
+	if err := json.Unmarshal([]byte(data), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T
+)
+
+func testStore(store Store) {
+	s1 := &Session{ID: "0a1b", UserID: 1, UserName: "alice", LastAccess: 100}
+	s2 := &Session{ID: "2c3d", UserID: 1, UserName: "alice", LastAccess: 200}
+	s3 := &Session{ID: "4e5f", UserID: 2, UserName: "bob", LastAccess: 300}
+	anonymous := &Session{ID: "6a7b", LastAccess: 300}
+	for _, s := range []*Session{s1, s2, s3, anonymous} {
+		assert(store.Save(s) == nil, "Save should succeed.")
+	}
+
+	got, err := store.Get("0a1b")
+	assert(err == nil && got.UserName == "alice", "Saved session should be returned.")
+
+	got.UserName = "changed"
+	got, _ = store.Get("0a1b")
+	assert(got.UserName == "alice", "Store should not share sessions with callers.")
+
+	_, err = store.Get("ffff")
+	assert(err == ErrSessionNotFound, "ErrSessionNotFound expected.")
+
+	sessions, err := store.ListByUser(1)
+	assert(err == nil && len(sessions) == 2, "User should have two sessions.")
+	sessions, err = store.ListByUser(0)
+	assert(err == nil && len(sessions) == 0, "Anonymous sessions should not belong to any user.")
+
+	assert(store.Touch("0a1b", 400) == nil, "Touch should succeed.")
+	got, _ = store.Get("0a1b")
+	assert(got.LastAccess == 400, "LastAccess should be updated.")
+
+	assert(store.GC(250, 0) == nil, "GC should succeed.")
+	_, err = store.Get("2c3d")
+	assert(err == ErrSessionNotFound, "Expired session should be removed.")
+	_, err = store.Get("0a1b")
+	assert(err == nil, "Touched session should not be removed.")
+
+	assert(store.Delete("4e5f") == nil, "Delete should succeed.")
+	assert(store.Delete("4e5f") == nil, "Deleting missing session is not an error.")
+	sessions, _ = store.ListByUser(2)
+	assert(len(sessions) == 0, "User should have no sessions.")
+}
+
+func TestMemoryStore(t *testing.T) {
+	_t = t
+	testStore(NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	_t = t
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(store)
+
+	_, err = store.Get("../../etc/passwd")
+	assert(err == ErrSessionNotFound, "Non hex ids should not be accepted.")
+}
+
+func TestReadRedisReply(t *testing.T) {
+	_t = t
+	r := bufio.NewReader(strings.NewReader("+OK\r\n$5\r\nhello\r\n$-1\r\n:42\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n-ERR wrong\r\n"))
+
+	reply, err := readRedisReply(r)
+	assert(err == nil && reply.(string) == "OK", "Simple string expected.")
+
+	reply, err = readRedisReply(r)
+	assert(err == nil && string(reply.([]byte)) == "hello", "Bulk string expected.")
+
+	reply, err = readRedisReply(r)
+	assert(err == nil && reply == nil, "Nil bulk string expected.")
+
+	reply, err = readRedisReply(r)
+	assert(err == nil && reply.(int64) == 42, "Integer expected.")
+
+	reply, err = readRedisReply(r)
+	array := reply.([]interface{})
+	assert(err == nil && len(array) == 2 && string(array[1].([]byte)) == "b", "Array expected.")
+
+	_, err = readRedisReply(r)
+	_, ok := err.(redisError)
+	assert(ok, "Redis error expected.")
+}
+
+func TestRedisTimeout(t *testing.T) {
+	_t = t
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(err == nil, "Listener should be started.")
+	defer l.Close()
+	go func() {
+		// accept connections, but never reply
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := &redisConn{addr: l.Addr().String(), timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err = c.do("GET", "key")
+	netErr, ok := err.(net.Error)
+	assert(ok && netErr.Timeout() && time.Since(start) < time.Second, "Command should time out.")
+	assert(c.conn == nil, "Connection should be dropped after timeout.")
+}
+
+func TestSessionData(t *testing.T) {
+	_t = t
+	type cart struct {
+		Items []string
+		Total int
+	}
+
+	s := &Session{ID: "0a1b"}
+	assert(!s.Dirty(), "New session should not be dirty.")
+
+	assert(s.Set("cart", cart{[]string{"apple"}, 3}) == nil, "Set should succeed.")
+	assert(s.Dirty(), "Session should be dirty after Set.")
+
+	var c cart
+	assert(s.Get("cart", &c) == nil && c.Total == 3 && c.Items[0] == "apple", "Stored value should be returned.")
+	assert(s.Get("missing", &c) == ErrNoValue, "ErrNoValue expected.")
+	assert(s.Value("cart").(map[string]interface{})["Total"].(float64) == 3, "Generic value expected.")
+
+	store := NewMemoryStore()
+	store.Save(s)
+	loaded, _ := store.Get("0a1b")
+	assert(!loaded.Dirty(), "Loaded session should not be dirty.")
+
+	loaded.Set("cart", cart{[]string{"apple"}, 3})
+	assert(!loaded.Dirty(), "Setting the same value should not make session dirty.")
+
+	loaded.Delete("cart")
+	assert(loaded.Dirty() && !loaded.Has("cart"), "Value should be deleted.")
+
+	stored, _ := store.Get("0a1b")
+	assert(stored.Has("cart"), "Store should not share data with callers.")
+
+	var nilSession *Session
+	assert(nilSession.Value("cart") == nil, "Value should be safe to call on nil session.")
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/solgar/upendo/database"
+	"github.com/solgar/upendo/settings"
+)
+
+// Names of user providers which can be selected using -user-provider setting.
+const (
+	UserProviderMemory = "memory"
+	UserProviderSQL    = "sql"
+)
+
+var (
+	// ErrUserNotFound is returned by user providers when there is no user
+	// with given login.
+	ErrUserNotFound = errors.New("User not found.")
+)
+
+// User is the account data needed to create session.
+type User struct {
+	ID    int
+	Login string
+}
+
+// UserProvider is the interface used by Manager to find users when sessions
+// are created.
+type UserProvider interface {
+	// LookupByLogin returns user with given login or ErrUserNotFound.
+	LookupByLogin(login string) (*User, error)
+	// RolesFor returns names of roles granted to user with given id.
+	RolesFor(userID int) ([]string, error)
+}
+
+// NewUserProviderFromSettings creates user provider selected with
+// -user-provider setting.
+func NewUserProviderFromSettings() (UserProvider, error) {
+	switch settings.UserProvider {
+	case UserProviderMemory:
+		return NewMemoryUserProvider(), nil
+	case "", UserProviderSQL:
+		db, err := database.Open(settings.DBDriver, settings.DBDSN)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLUserProvider(db), nil
+	}
+	return nil, errors.New("Unknown user provider: " + settings.UserProvider)
+}
+
+// SQLUserProvider finds users in User, Role and UserRole tables:
+//
+//	User(id, login)
+//	Role(id, name)
+//	UserRole(user_id, role_id)
+type SQLUserProvider struct {
+	db *sql.DB
+}
+
+// NewSQLUserProvider creates SQLUserProvider using given database.
+func NewSQLUserProvider(db *sql.DB) *SQLUserProvider {
+	return &SQLUserProvider{db}
+}
+
+func (p *SQLUserProvider) LookupByLogin(login string) (*User, error) {
+	user := &User{Login: login}
+	err := p.db.QueryRow("SELECT id FROM User WHERE login=?", login).Scan(&user.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (p *SQLUserProvider) RolesFor(userID int) ([]string, error) {
+	rows, err := p.db.Query("SELECT Role.name FROM Role INNER JOIN UserRole ON UserRole.role_id=Role.id WHERE UserRole.user_id=?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]string, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// MemoryUserProvider keeps users in memory, it's meant for tests and small
+// applications with a handful of accounts.
+type MemoryUserProvider struct {
+	mutex sync.RWMutex
+	users map[string]*User
+	roles map[int][]string
+}
+
+// NewMemoryUserProvider creates MemoryUserProvider without users.
+func NewMemoryUserProvider() *MemoryUserProvider {
+	return &MemoryUserProvider{users: make(map[string]*User), roles: make(map[int][]string)}
+}
+
+// AddUser adds user with given id, login and roles. Existing user with the
+// same login is replaced.
+func (p *MemoryUserProvider) AddUser(id int, login string, roles ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.users[login] = &User{id, login}
+	p.roles[id] = append([]string(nil), roles...)
+}
+
+func (p *MemoryUserProvider) LookupByLogin(login string) (*User, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	user, ok := p.users[login]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &User{user.ID, user.Login}, nil
+}
+
+func (p *MemoryUserProvider) RolesFor(userID int) ([]string, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]string(nil), p.roles[userID]...), nil
+}
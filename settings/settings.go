@@ -3,6 +3,7 @@ package settings
 import (
 	"flag"
 	"strings"
+	"time"
 )
 
 // Customize settings by passing proper parameters. See ./upendo -h for details.
@@ -13,6 +14,9 @@ var (
 	// determines on which port upendo will run
 	ServicePort string
 
+	// comma separated list of addresses to listen on, overrides ServicePort
+	Listen string
+
 	// if set to yes all templates will be reloaded on each request
 	ReloadTemplates bool
 
@@ -28,12 +32,229 @@ var (
 	// should sessions be restored from file when launching upendo
 	RestoreSessions bool
 
+	// server keeps sessions in session store, cookie keeps them in signed cookies
+	SessionMode string
+
+	// comma separated secrets for cookie sessions, first signs, all verify
+	SessionCookieKeys string
+
+	// should cookie sessions be encrypted
+	SessionCookieEncrypt bool
+
+	// where sessions are kept: memory, file, sql or redis
+	SessionStore string
+
+	// directory (file), data source name (sql) or address (redis) of session store
+	SessionStoreAddr string
+
+	// database driver used by sql session store
+	SessionStoreDriver string
+
+	// table used by sql session store
+	SessionStoreTable string
+
+	// session expires when not used for this long
+	SessionIdleTimeout time.Duration
+
+	// session expires this long after creation regardless of activity, 0 disables
+	SessionAbsoluteTimeout time.Duration
+
+	// how often expired sessions are removed from store
+	SessionCleanupInterval time.Duration
+
+	// name of cookie holding session id
+	SessionCookieName string
+
+	// session cookie attributes
+	SessionCookiePath     string
+	SessionCookieDomain   string
+	SessionCookieSecure   bool
+	SessionCookieHTTPOnly bool
+	SessionCookieSameSite string
+
+	// if set session cookie is persistent and expires after this long, renewed together with session
+	SessionCookieMaxAge time.Duration
+
+	// limits number of concurrent sessions of single user, least recently used are expired, 0 disables
+	MaxSessionsPerUser int
+
 	// limits chain of routing calls to specified value
 	RoutingChainMax int
 
+	// where users are looked up when sessions are created: memory or sql
+	UserProvider string
+
+	// database used by sql user provider
+	DBDriver string
+	DBDSN    string
+
+	// paths of built-in login and logout pages
+	LoginURL  string
+	LogoutURL string
+
+	// where user is sent after logging in (unless return URL is given) and out
+	LoginRedirect  string
+	LogoutRedirect string
+
+	// template used to render login page, built-in form is used if not found
+	LoginTemplate string
+
+	// how long "remember me" login lasts, 0 disables it
+	RememberMeDuration time.Duration
+
+	// path and template of second factor page, built-in form is used if
+	// template is not found
+	TwoFactorURL      string
+	TwoFactorTemplate string
+
+	// issuer shown by authenticator apps
+	TOTPIssuer string
+
+	// TOTP periods accepted before and after current one
+	TOTPWindow int
+
+	// number of recovery codes generated on enrollment
+	RecoveryCodes int
+
+	// names of cookie, header and form field holding CSRF token
+	CSRFCookieName string
+	CSRFHeader     string
+	CSRFField      string
+
+	// Content-Security-Policy, {nonce} is replaced with nonce of the request,
+	// empty disables the header
+	ContentSecurityPolicy string
+
+	// if CSP violations are only reported instead of blocked
+	CSPReportOnly bool
+
+	// path of built-in CSP report collection endpoint, empty disables it
+	CSPReportURI string
+
+	// values of X-Frame-Options, Referrer-Policy and Permissions-Policy
+	// headers, empty disables header
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+
+	// max-age of Strict-Transport-Security sent over HTTPS, 0 disables it
+	HSTSMaxAge time.Duration
+
+	// comma separated origins allowed to make cross-origin requests to all
+	// paths, e.g. "https://*.example.com", empty disables default CORS policy
+	CORSOrigins string
+
+	// comma separated methods and request headers allowed by default CORS
+	// policy
+	CORSMethods string
+	CORSHeaders string
+
+	// comma separated response headers exposed to cross-origin scripts
+	CORSExposedHeaders string
+
+	// if cross-origin requests can carry cookies
+	CORSCredentials bool
+
+	// how long browsers can cache preflight responses
+	CORSMaxAge time.Duration
+
+	// JSON file with role definitions, see session.RoleDefinition
+	RolesFile string
+
+	// failed logins allowed before login is locked, 0 disables lockout
+	LoginMaxFailures int
+
+	// first lock duration, doubled with every next failure up to max
+	LoginLockout    time.Duration
+	LoginLockoutMax time.Duration
+
+	// login attempts allowed per minute from single IP, 0 disables limit
+	LoginRateLimit int
+
+	// comma separated "id:secret" keys of bearer tokens, first one signs, all
+	// verify
+	JWTKeys string
+
+	// issuer and audience of bearer tokens, checked if not empty
+	JWTIssuer   string
+	JWTAudience string
+
+	// lifetime of issued bearer tokens
+	JWTTokenTTL time.Duration
+
+	// OpenID Connect provider used to log in, discovered from issuer URL,
+	// disabled if empty
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+
+	// absolute callback URL registered at the provider
+	OIDCRedirectURL string
+
+	// space separated scopes requested from the provider
+	OIDCScopes string
+
+	// ID token claim used as user login
+	OIDCLoginClaim string
+
+	// path starting OpenID Connect login
+	OIDCLoginURL string
+
+	// algorithm of new password hashes: pbkdf2, bcrypt or argon2id
+	PasswordHash string
+
+	// cost parameters of password hashes, hashes with other parameters are
+	// rehashed when user logs in
+	PasswordPBKDF2Iterations int
+	PasswordBcryptCost       int
+	PasswordArgon2Memory     int
+	PasswordArgon2Time       int
+	PasswordArgon2Threads    int
+
 	// if map files are ignored (js.map, css.map)
 	IgnoreMapFiles bool
 
+	// comma separated "/prefix=directory" pairs of static files
+	StaticDirs string
+
+	// Cache-Control header of static files, not sent if empty
+	StaticCacheControl string
+
+	// if static files are served also at content-hashed URLs
+	FingerprintAssets bool
+
+	// Cache-Control header of files served at content-hashed URLs
+	AssetCacheControl string
+
+	// JSON manifest of prebuilt fingerprinted assets
+	AssetManifest string
+
+	// responses smaller than that are not compressed
+	CompressMinSize int
+
+	// comma separated content types (or prefixes ending with "/") which are
+	// compressed
+	CompressTypes string
+
+	// gzip compression level, 1 (fastest) to 9 (best)
+	CompressLevel int
+
+	// directory where uploaded files are stored until handled
+	UploadDir string
+
+	// limit of single uploaded file in bytes
+	UploadMaxFileSize int64
+
+	// limit of whole upload request body in bytes
+	UploadMaxSize int64
+
+	// limit of files in single upload request
+	UploadMaxFiles int
+
+	// comma separated content types (or prefixes ending with "/") of files
+	// which can be uploaded, checked against type sniffed from content
+	UploadTypes string
+
 	// cert file
 	CertFile string
 
@@ -47,12 +268,96 @@ var (
 func Initialize() {
 	flag.StringVar(&StartDir, "start-dir", "", "app start directory, defaults to \".\"")
 	flag.StringVar(&ServicePort, "port", "8080", "port for service to listen on")
-	flag.BoolVar(&ReloadTemplates, "reload-templates", false, "if \"true\" then on each request page templates are reloaded")
+	flag.StringVar(&Listen, "listen", "", "comma separated list of \"[name=]host:port\", \"[name=]unix:/path.sock\", \"[name=]fd:N\" or \"systemd\" listeners, overrides -port")
+	flag.BoolVar(&ReloadTemplates, "reload-templates", false, "if \"true\" then on each request page templates are reloaded, ignored if templates aren't read from disk")
 	flag.BoolVar(&RequireTemplates, "require-templates", false, "if \"true\" then panic if no templates can be found, ignore otherwise")
 	flag.StringVar(&TemplatesDir, "templates-dir", "templates", "default relative location to look for templates")
 	flag.BoolVar(&ArchiveSessions, "archive-sessions", true, "if \"true\" upon closing active sessions are archived to file")
 	flag.BoolVar(&RestoreSessions, "restore-sessions", true, "if \"true\" restores previously active sessions")
+	flag.StringVar(&SessionMode, "session-mode", "server", "\"server\" keeps sessions in session store, \"cookie\" keeps whole sessions in signed cookies")
+	flag.StringVar(&SessionCookieKeys, "session-cookie-keys", "", "comma separated secrets (at least 16 characters) for cookie sessions, first one signs, all verify")
+	flag.BoolVar(&SessionCookieEncrypt, "session-cookie-encrypt", false, "if \"true\" cookie sessions are encrypted with AES-GCM")
+	flag.StringVar(&SessionStore, "session-store", "memory", "where sessions are kept: \"memory\", \"file\", \"sql\" or \"redis\"")
+	flag.StringVar(&SessionStoreAddr, "session-store-addr", "", "relative directory (file), data source name (sql) or host:port (redis) of session store")
+	flag.StringVar(&SessionStoreDriver, "session-store-driver", "mysql", "database driver used by \"sql\" session store")
+	flag.StringVar(&SessionStoreTable, "session-store-table", "Session", "table used by \"sql\" session store")
+	flag.DurationVar(&SessionIdleTimeout, "session-idle-timeout", time.Hour, "session expires when not used for this long")
+	flag.DurationVar(&SessionAbsoluteTimeout, "session-absolute-timeout", 24*time.Hour, "session expires this long after creation regardless of activity, 0 disables")
+	flag.DurationVar(&SessionCleanupInterval, "session-cleanup-interval", 10*time.Minute, "how often expired sessions are removed")
+	flag.StringVar(&SessionCookieName, "session-cookie-name", "data", "name of cookie holding session id")
+	flag.StringVar(&SessionCookiePath, "session-cookie-path", "/", "Path attribute of session cookie")
+	flag.StringVar(&SessionCookieDomain, "session-cookie-domain", "", "Domain attribute of session cookie")
+	flag.BoolVar(&SessionCookieSecure, "session-cookie-secure", false, "if \"true\" session cookie is sent only over HTTPS")
+	flag.BoolVar(&SessionCookieHTTPOnly, "session-cookie-httponly", true, "if \"true\" session cookie is not available to scripts")
+	flag.StringVar(&SessionCookieSameSite, "session-cookie-samesite", "lax", "SameSite attribute of session cookie: \"lax\", \"strict\", \"none\" or empty")
+	flag.DurationVar(&SessionCookieMaxAge, "session-cookie-max-age", 0, "if set session cookie is persistent and expires after this long since last use")
+	flag.IntVar(&MaxSessionsPerUser, "max-sessions-per-user", 0, "limits number of concurrent sessions of single user, least recently used are expired, 0 disables")
+	flag.StringVar(&UserProvider, "user-provider", "sql", "where users are looked up when sessions are created: \"sql\" or \"memory\" (for tests, users have to be added in code)")
+	flag.StringVar(&DBDriver, "db-driver", "mysql", "database driver used by \"sql\" user provider")
+	flag.StringVar(&DBDSN, "db-dsn", "", "data source name of database used by \"sql\" user provider, e.g. \"user:pass@/dbname\"")
+	flag.StringVar(&LoginURL, "login-url", "/login", "path of login page")
+	flag.StringVar(&LogoutURL, "logout-url", "/logout", "path of logout action")
+	flag.StringVar(&LoginRedirect, "login-redirect", "/", "where user is sent after logging in when no return URL is given")
+	flag.StringVar(&LogoutRedirect, "logout-redirect", "/", "where user is sent after logging out")
+	flag.StringVar(&LoginTemplate, "login-template", "login.html", "template used to render login page, built-in form is used if not found")
+	flag.DurationVar(&RememberMeDuration, "remember-me-duration", 30*24*time.Hour, "how long \"remember me\" login lasts, 0 disables it")
+	flag.StringVar(&TwoFactorURL, "two-factor-url", "/login/2fa", "path of page confirming second factor")
+	flag.StringVar(&TwoFactorTemplate, "two-factor-template", "2fa.html", "template used to render second factor page, built-in form is used if not found")
+	flag.StringVar(&TOTPIssuer, "totp-issuer", "upendo", "issuer shown by authenticator apps")
+	flag.IntVar(&TOTPWindow, "totp-window", 1, "TOTP periods accepted before and after current one to tolerate clock drift")
+	flag.IntVar(&RecoveryCodes, "recovery-codes", 10, "number of recovery codes generated on two-factor enrollment")
+	flag.StringVar(&CSRFCookieName, "csrf-cookie-name", "csrf", "name of cookie holding CSRF token of visitors without stored session")
+	flag.StringVar(&CSRFHeader, "csrf-header", "X-CSRF-Token", "request header which can hold CSRF token, e.g. for AJAX requests")
+	flag.StringVar(&CSRFField, "csrf-field", "csrf_token", "form field holding CSRF token")
+	flag.StringVar(&ContentSecurityPolicy, "csp", "default-src 'self'; script-src 'self' {nonce}; style-src 'self' {nonce}; object-src 'none'; base-uri 'self'; frame-ancestors 'self'", "Content-Security-Policy header, {nonce} is replaced with per request nonce, empty disables it")
+	flag.BoolVar(&CSPReportOnly, "csp-report-only", false, "if \"true\" Content-Security-Policy-Report-Only header is sent instead, violations are only reported")
+	flag.StringVar(&CSPReportURI, "csp-report-uri", "", "path of built-in CSP report collection endpoint, e.g. \"/csp-report\", empty disables it")
+	flag.StringVar(&FrameOptions, "frame-options", "SAMEORIGIN", "X-Frame-Options header, empty disables it")
+	flag.StringVar(&ReferrerPolicy, "referrer-policy", "strict-origin-when-cross-origin", "Referrer-Policy header, empty disables it")
+	flag.StringVar(&PermissionsPolicy, "permissions-policy", "camera=(), microphone=(), geolocation=()", "Permissions-Policy header, empty disables it")
+	flag.DurationVar(&HSTSMaxAge, "hsts-max-age", 180*24*time.Hour, "max-age of Strict-Transport-Security header sent over HTTPS, 0 disables it")
+	flag.StringVar(&CORSOrigins, "cors-origins", "", "comma separated origins allowed to make cross-origin requests, \"*\" wildcards are allowed, empty disables CORS")
+	flag.StringVar(&CORSMethods, "cors-methods", "GET,HEAD,POST", "comma separated methods allowed in cross-origin requests")
+	flag.StringVar(&CORSHeaders, "cors-headers", "Content-Type,X-CSRF-Token", "comma separated request headers allowed in cross-origin requests")
+	flag.StringVar(&CORSExposedHeaders, "cors-exposed-headers", "", "comma separated response headers exposed to cross-origin scripts")
+	flag.BoolVar(&CORSCredentials, "cors-credentials", false, "if \"true\" cross-origin requests can carry cookies")
+	flag.DurationVar(&CORSMaxAge, "cors-max-age", 10*time.Minute, "how long browsers can cache preflight responses")
+	flag.StringVar(&RolesFile, "roles-file", "", "JSON file with roles and permissions granted to them")
+	flag.IntVar(&LoginMaxFailures, "login-max-failures", 5, "failed logins allowed before login is locked, 0 disables lockout")
+	flag.DurationVar(&LoginLockout, "login-lockout", 30*time.Second, "first login lock duration, doubled with every next failure")
+	flag.DurationVar(&LoginLockoutMax, "login-lockout-max", time.Hour, "maximal login lock duration")
+	flag.IntVar(&LoginRateLimit, "login-rate-limit", 20, "login attempts allowed per minute from single IP, 0 disables limit")
+	flag.StringVar(&JWTKeys, "jwt-keys", "", "comma separated \"id:secret\" keys of bearer tokens, first one signs tokens, all verify them")
+	flag.StringVar(&JWTIssuer, "jwt-issuer", "", "issuer of bearer tokens, checked if not empty")
+	flag.StringVar(&JWTAudience, "jwt-audience", "", "audience of bearer tokens, checked if not empty")
+	flag.DurationVar(&JWTTokenTTL, "jwt-token-ttl", time.Hour, "lifetime of issued bearer tokens")
+	flag.StringVar(&OIDCIssuer, "oidc-issuer", "", "issuer URL of OpenID Connect provider, empty disables OpenID Connect login")
+	flag.StringVar(&OIDCClientID, "oidc-client-id", "", "client id registered at OpenID Connect provider")
+	flag.StringVar(&OIDCClientSecret, "oidc-client-secret", "", "client secret registered at OpenID Connect provider, empty for public clients")
+	flag.StringVar(&OIDCRedirectURL, "oidc-redirect-url", "", "absolute callback URL registered at OpenID Connect provider")
+	flag.StringVar(&OIDCScopes, "oidc-scopes", "openid email profile", "space separated scopes requested from OpenID Connect provider")
+	flag.StringVar(&OIDCLoginClaim, "oidc-login-claim", "email", "ID token claim used as user login")
+	flag.StringVar(&OIDCLoginURL, "oidc-login-url", "/login/oidc", "path starting OpenID Connect login")
+	flag.StringVar(&PasswordHash, "password-hash", "argon2id", "algorithm of new password hashes: \"pbkdf2\", \"bcrypt\" or \"argon2id\"")
+	flag.IntVar(&PasswordPBKDF2Iterations, "password-pbkdf2-iterations", 310000, "iterations of pbkdf2 password hashes")
+	flag.IntVar(&PasswordBcryptCost, "password-bcrypt-cost", 12, "cost of bcrypt password hashes")
+	flag.IntVar(&PasswordArgon2Memory, "password-argon2-memory", 64*1024, "memory in KiB used by argon2id password hashes")
+	flag.IntVar(&PasswordArgon2Time, "password-argon2-time", 3, "iterations of argon2id password hashes")
+	flag.IntVar(&PasswordArgon2Threads, "password-argon2-threads", 2, "threads used by argon2id password hashes")
 	flag.BoolVar(&IgnoreMapFiles, "ignore-map-files", true, "if \"true\" \"file not found\" errors for .map files will be ignored")
+	flag.StringVar(&StaticDirs, "static-dirs", "/res=res,/css=css,/js=js", "comma separated \"/prefix=directory\" pairs, files from directories are served at paths starting with prefixes")
+	flag.StringVar(&StaticCacheControl, "static-cache-control", "public, max-age=3600", "Cache-Control header of static files, not sent if empty")
+	flag.BoolVar(&FingerprintAssets, "fingerprint-assets", false, "if \"true\" static files are hashed at startup and served also at content-hashed URLs returned by \"asset\" template function")
+	flag.StringVar(&AssetCacheControl, "asset-cache-control", "public, max-age=31536000, immutable", "Cache-Control header of static files served at content-hashed URLs")
+	flag.StringVar(&AssetManifest, "asset-manifest", "", "relative location of JSON manifest mapping asset names to URLs of prebuilt fingerprinted files, e.g. {\"/css/site.css\": \"/css/site.3f2a9c1d.css\"}")
+	flag.IntVar(&CompressMinSize, "compress-min-size", 1024, "responses smaller than that many bytes are not compressed")
+	flag.StringVar(&CompressTypes, "compress-types", "text/,application/javascript,application/json,application/xml,image/svg+xml", "comma separated content types (or prefixes ending with \"/\") which are compressed")
+	flag.IntVar(&CompressLevel, "compress-level", 6, "gzip compression level, 1 (fastest) to 9 (best)")
+	flag.StringVar(&UploadDir, "upload-dir", "", "directory where uploaded files are stored until handled, defaults to system temporary directory")
+	flag.Int64Var(&UploadMaxFileSize, "upload-max-file-size", 10<<20, "limit of single uploaded file in bytes")
+	flag.Int64Var(&UploadMaxSize, "upload-max-size", 32<<20, "limit of whole upload request body in bytes")
+	flag.IntVar(&UploadMaxFiles, "upload-max-files", 10, "limit of files in single upload request")
+	flag.StringVar(&UploadTypes, "upload-types", "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain", "comma separated content types (or prefixes ending with \"/\") of files which can be uploaded, sniffed from content, any type if empty")
 	flag.IntVar(&RoutingChainMax, "routing-chain-max", 4, "limits maximum routing calls to specified value")
 	flag.BoolVar(&LoadSettingsFromFile, "settings-from-file", false, "if \"true\" tries to read settings from settings.json - *not implemented yet*")
 	flag.StringVar(&CertFile, "cert-file", "", "relative location of cert file")
@@ -1,25 +1,52 @@
 package upendo
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/solgar/upendo/controller"
 	"github.com/solgar/upendo/controller/resources"
+	"github.com/solgar/upendo/listener"
 	"github.com/solgar/upendo/pages"
 	"github.com/solgar/upendo/router"
 	"github.com/solgar/upendo/session"
 	"github.com/solgar/upendo/settings"
 )
 
+const (
+	// how long to wait for active requests when shutting down
+	shutdownTimeout = 30 * time.Second
+)
+
 // Upendo version variables
 var (
 	VersionMajor = "0"
 	VersionMinor = "1"
 )
 
+var (
+	servers        []*http.Server
+	listeners      []*listener.Listener
+	stopped        = make(chan struct{})
+	setupFunctions []func()
+)
+
+// AddSetupFunc adds function called by Start after settings are initialized
+// and before templates are loaded. Modules which depend on settings or
+// register template functions should be installed this way:
+//
+//	upendo.AddSetupFunc(session.Initialize)
+//	upendo.AddSetupFunc(auth.Install)
+func AddSetupFunc(f func()) {
+	setupFunctions = append(setupFunctions, f)
+}
+
 // Start function starts upendo application with given name
 func Start(appName string) {
 	settings.Initialize()
@@ -27,19 +54,61 @@ func Start(appName string) {
 	controller.RegisterPreRouteFunctions()
 	controller.InstallErrors()
 	resources.Install()
+	for _, f := range setupFunctions {
+		f()
+	}
 
 	fmt.Printf("upendo ver %s", VersionMajor+"."+VersionMinor)
 	fmt.Printf(" running: %s", appName)
 	fmt.Println()
 
+	specs := listener.Parse(settings.Listen)
+	if len(specs) == 0 {
+		specs = []string{":" + settings.ServicePort}
+	}
+
+	var err error
+	listeners, err = listener.Open(specs)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	setup()
 
-	fmt.Println("Listening on port", ":"+settings.ServicePort)
 	http.HandleFunc("/", router.RouteRequest)
-	if settings.CertFile != "" && settings.KeyFile != "" {
-		fmt.Println(http.ListenAndServeTLS(":"+settings.ServicePort, settings.CertFile, settings.KeyFile, nil))
-	} else {
-		fmt.Println(http.ListenAndServe(":"+settings.ServicePort, nil))
+	serve()
+}
+
+// serve runs http server for each listener and returns when all of them stop.
+func serve() {
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		srv := &http.Server{BaseContext: listener.BaseContext(l)}
+		servers = append(servers, srv)
+
+		fmt.Println("Listening on", l.Spec, "("+l.Name+")")
+		wg.Add(1)
+		go func(l *listener.Listener) {
+			defer wg.Done()
+			var err error
+			if settings.CertFile != "" && settings.KeyFile != "" {
+				err = srv.ServeTLS(l, settings.CertFile, settings.KeyFile)
+			} else {
+				err = srv.Serve(l)
+			}
+			if err != http.ErrServerClosed {
+				fmt.Println(err)
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	select {
+	case <-stopped:
+		// wait for signal handler to finish
+		select {}
+	default:
 	}
 }
 
@@ -53,11 +122,33 @@ func setup() {
 // TODO: move it to separate package and parametrize
 func listenToSignals() {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	s := <-c
+	for s := range c {
+		fmt.Println("Received signal:", s.String())
+		if s != syscall.SIGHUP {
+			break
+		}
 
-	fmt.Println("Received signal:", s.String())
+		// on SIGHUP new instance takes over listening sockets before this
+		// one finishes serving active requests
+		p, err := listener.Handoff(listeners)
+		if err != nil {
+			fmt.Println("Cannot hand off listeners:", err)
+			continue
+		}
+		fmt.Println("Listeners handed off to process", p.Pid)
+		break
+	}
+	close(stopped)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Println("Cannot shut down server:", err)
+		}
+	}
 
 	session.Deinit()
 
@@ -0,0 +1,43 @@
+package upload
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Storage keeps uploaded files. Receive writes every file to writer returned
+// by Create and removes it with Remove if the upload is rejected or the file
+// isn't kept by the handler.
+type Storage interface {
+	// Create returns writer of new file and id identifying it in storage.
+	Create(f *File) (io.WriteCloser, string, error)
+	Open(id string) (io.ReadCloser, error)
+	Remove(id string) error
+}
+
+// DirStorage stores files in directory on disk, id of file is its path.
+type DirStorage struct {
+	// defaults to system temporary directory if empty
+	Dir string
+}
+
+func (d *DirStorage) Create(f *File) (io.WriteCloser, string, error) {
+	file, err := ioutil.TempFile(d.Dir, "upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+	return file, file.Name(), nil
+}
+
+func (d *DirStorage) Open(id string) (io.ReadCloser, error) {
+	return os.Open(id)
+}
+
+func (d *DirStorage) Remove(id string) error {
+	err := os.Remove(id)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
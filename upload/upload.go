@@ -0,0 +1,409 @@
+// Package upload receives multipart/form-data uploads. Files are streamed to
+// storage (temporary directory by default) while the body is read, they're
+// never kept in memory. Size limits and types sniffed from file content are
+// checked on the way, type sent by client isn't trusted. Stored files are
+// removed when the request is finished, unless the handler keeps them:
+//
+//	func (c Controller) Avatar() {
+//		form, err := upload.Receive(c, &upload.Options{Types: []string{"image/png", "image/jpeg"}})
+//		if err != nil {
+//			router.RouteToError(c, upload.StatusCode(err))
+//			return
+//		}
+//		controller.PanicIfNeeded(form.File("avatar").MoveTo("avatars/" + login))
+//	}
+//
+// Routes receiving uploads should be marked with csrf.Deferred, so the body
+// isn't parsed by csrf package before the handler. Token field has to precede
+// files in such forms, requests without it are rejected before the handler.
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/solgar/upendo/router"
+	"github.com/solgar/upendo/settings"
+)
+
+const (
+	// how much of file content is used to detect its type
+	sniffSize = 512
+	// limit of single form value
+	maxValueSize = 1 << 20
+)
+
+var (
+	// ErrNotMultipart is returned for requests which aren't multipart/form-data.
+	ErrNotMultipart = errors.New("Request isn't multipart/form-data.")
+	// ErrBodyRead is returned when request body was parsed before the handler.
+	ErrBodyRead = errors.New("Request body already read, mark the route with csrf.Deferred.")
+	// ErrInvalidBody is returned when request body can't be read or parsed.
+	ErrInvalidBody = errors.New("Invalid upload request body.")
+	// ErrTooLarge is returned when request body exceeds MaxSize.
+	ErrTooLarge = errors.New("Upload too large.")
+	// ErrFileTooLarge is returned when single file exceeds MaxFileSize.
+	ErrFileTooLarge = errors.New("Uploaded file too large.")
+	// ErrTooManyFiles is returned when request has more than MaxFiles files.
+	ErrTooManyFiles = errors.New("Too many uploaded files.")
+	// ErrTypeNotAllowed is returned when sniffed type of file isn't allowed.
+	ErrTypeNotAllowed = errors.New("Type of uploaded file not allowed.")
+
+	defaultStorage Storage
+)
+
+// Options of Receive, zero fields default to -upload-* settings.
+type Options struct {
+	MaxFileSize int64
+	MaxSize     int64
+	MaxFiles    int
+	// allowed content types or prefixes ending with "/", "*/*" allows any
+	Types   []string
+	Storage Storage
+	// called whenever part of file is stored
+	Progress func(p Progress)
+}
+
+// Progress of upload passed to Options.Progress.
+type Progress struct {
+	// file being stored, its Size grows as it's stored
+	File *File
+	// bytes of request body read so far
+	Read int64
+	// Content-Length of request, -1 if unknown
+	Total int64
+}
+
+// File is uploaded file kept in storage.
+type File struct {
+	Field string
+	// base name of file sent by client, it's not safe to use it as path
+	Filename string
+	// type sniffed from content
+	ContentType string
+	Size        int64
+	// id of file in Storage, path of file for DirStorage
+	ID      string
+	Storage Storage
+
+	kept    bool
+	removed bool
+}
+
+// Form holds values and files of received upload.
+type Form struct {
+	Values url.Values
+	Files  []*File
+}
+
+// SetStorage replaces default storage, DirStorage of -upload-dir.
+func SetStorage(storage Storage) {
+	defaultStorage = storage
+}
+
+// Open returns content of stored file.
+func (f *File) Open() (io.ReadCloser, error) {
+	return f.Storage.Open(f.ID)
+}
+
+// Keep prevents removing of stored file when request is finished.
+func (f *File) Keep() {
+	f.kept = true
+}
+
+// MoveTo moves file to given path. Files of DirStorage are renamed if possible,
+// other files are copied. File can't be opened after it's moved.
+func (f *File) MoveTo(name string) error {
+	if _, ok := f.Storage.(*DirStorage); ok && os.Rename(f.ID, name) == nil {
+		f.kept = true
+		return nil
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// File returns first file sent in given field, nil if there is none.
+func (f *Form) File(field string) *File {
+	for _, file := range f.Files {
+		if file.Field == field {
+			return file
+		}
+	}
+	return nil
+}
+
+// Cleanup removes stored files which weren't kept. Receive makes router call
+// it when request is finished.
+func (f *Form) Cleanup() {
+	for _, file := range f.Files {
+		if file.kept || file.removed {
+			continue
+		}
+		if err := file.Storage.Remove(file.ID); err != nil {
+			fmt.Println("Error:", err)
+		}
+		file.removed = true
+	}
+}
+
+// StatusCode returns status of response to upload rejected with err.
+func StatusCode(err error) int {
+	switch err {
+	case ErrNotMultipart, ErrTypeNotAllowed:
+		return http.StatusUnsupportedMediaType
+	case ErrTooLarge, ErrFileTooLarge, ErrTooManyFiles:
+		return http.StatusRequestEntityTooLarge
+	case ErrInvalidBody:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Receive reads multipart/form-data body of request, storing files and
+// collecting other values. Upload is rejected as soon as limit is exceeded or
+// file of not allowed type is found, stored files are removed then. opts may
+// be nil.
+func Receive(c map[string]interface{}, opts *Options) (*Form, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.setDefaults()
+
+	r := c["request"].(*http.Request)
+	if r.MultipartForm != nil {
+		return nil, ErrBodyRead
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ErrNotMultipart
+	}
+	if r.ContentLength > o.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	rc := &receiver{
+		o:     o,
+		body:  &limitedBody{r: r.Body, limit: o.MaxSize},
+		total: r.ContentLength,
+		form:  &Form{Values: url.Values{}},
+	}
+	if err := rc.receive(multipart.NewReader(rc.body, params["boundary"])); err != nil {
+		rc.form.Cleanup()
+		return nil, err
+	}
+	router.AfterRequest(c, rc.form.Cleanup)
+	return rc.form, nil
+}
+
+func (o *Options) setDefaults() {
+	if o.MaxFileSize <= 0 {
+		o.MaxFileSize = settings.UploadMaxFileSize
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = settings.UploadMaxSize
+	}
+	if o.MaxFiles <= 0 {
+		o.MaxFiles = settings.UploadMaxFiles
+	}
+	if o.Types == nil {
+		for _, t := range strings.Split(settings.UploadTypes, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				o.Types = append(o.Types, t)
+			}
+		}
+	}
+	if o.Storage == nil {
+		o.Storage = defaultStorage
+	}
+	if o.Storage == nil {
+		o.Storage = &DirStorage{settings.UploadDir}
+	}
+}
+
+// allowed reports if file of given content type can be uploaded.
+func (o *Options) allowed(contentType string) bool {
+	if len(o.Types) == 0 {
+		return true
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	for _, t := range o.Types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "*/*" || t == mediaType || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseName strips directories some browsers send in file name.
+func baseName(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// limitedBody counts bytes of request body and fails when limit is exceeded.
+type limitedBody struct {
+	r        io.Reader
+	read     int64
+	limit    int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrTooLarge
+	}
+	if left := b.limit - b.read + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.exceeded = true
+		return 0, ErrTooLarge
+	}
+	return n, err
+}
+
+// receiver holds state of single Receive call.
+type receiver struct {
+	o     Options
+	body  *limitedBody
+	total int64
+	form  *Form
+}
+
+// bodyError returns error of upload which body couldn't be read.
+func (rc *receiver) bodyError() error {
+	if rc.body.exceeded {
+		return ErrTooLarge
+	}
+	return ErrInvalidBody
+}
+
+func (rc *receiver) receive(mr *multipart.Reader) error {
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rc.bodyError()
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxValueSize+1))
+			if err != nil {
+				return rc.bodyError()
+			}
+			if len(value) > maxValueSize {
+				return ErrTooLarge
+			}
+			rc.form.Values.Add(name, string(value))
+			continue
+		}
+
+		if len(rc.form.Files) >= rc.o.MaxFiles {
+			return ErrTooManyFiles
+		}
+		f, err := rc.store(part)
+		if err != nil {
+			return err
+		}
+		rc.form.Files = append(rc.form.Files, f)
+	}
+	return nil
+}
+
+// store sniffs type of file in part and copies it to storage.
+func (rc *receiver) store(part *multipart.Part) (*File, error) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, rc.bodyError()
+	}
+	head = head[:n]
+	f := &File{
+		Field:       part.FormName(),
+		Filename:    baseName(part.FileName()),
+		ContentType: http.DetectContentType(head),
+		Storage:     rc.o.Storage,
+	}
+	if !rc.o.allowed(f.ContentType) {
+		return nil, ErrTypeNotAllowed
+	}
+
+	w, id, err := f.Storage.Create(f)
+	if err != nil {
+		return nil, err
+	}
+	f.ID = id
+	err = rc.copy(w, io.MultiReader(bytes.NewReader(head), part), f)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		f.Storage.Remove(id)
+		return nil, err
+	}
+	return f, nil
+}
+
+// copy writes file content to storage, reporting progress.
+func (rc *receiver) copy(w io.Writer, r io.Reader, f *File) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if f.Size+int64(n) > rc.o.MaxFileSize {
+				return ErrFileTooLarge
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			f.Size += int64(n)
+			if rc.o.Progress != nil {
+				rc.o.Progress(Progress{f, rc.body.read, rc.total})
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return rc.bodyError()
+		}
+	}
+}
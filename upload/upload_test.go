@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/solgar/upendo/settings"
+)
+
+func assert(trueStatement bool, msg string) {
+	if !trueStatement {
+		_t.Error(msg)
+	}
+}
+
+var (
+	_t *testing.T = nil
+
+	png = "\x89PNG\r\n\x1a\n" + strings.Repeat("image data ", 100)
+)
+
+// request builds upload request of "name=value" fields and "field:file=content"
+// files.
+func request(parts ...string) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		if field := strings.SplitN(kv[0], ":", 2); len(field) == 2 {
+			w, _ := mw.CreateFormFile(field[0], field[1])
+			w.Write([]byte(kv[1]))
+		} else {
+			mw.WriteField(kv[0], kv[1])
+		}
+	}
+	mw.Close()
+	r := httptest.NewRequest("POST", "/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func stored(dir string) int {
+	files, _ := ioutil.ReadDir(dir)
+	return len(files)
+}
+
+func setup() string {
+	dir, err := ioutil.TempDir("", "uploads")
+	assert(err == nil, "Temp dir should be created.")
+	settings.UploadDir, settings.UploadMaxFileSize, settings.UploadMaxSize = dir, 1<<20, 4<<20
+	settings.UploadMaxFiles, settings.UploadTypes = 2, "image/,text/plain"
+	return dir
+}
+
+func TestReceive(t *testing.T) {
+	_t = t
+	dir := setup()
+	defer os.RemoveAll(dir)
+
+	progress := make(map[string]int64)
+	c := map[string]interface{}{"request": request("title=Holidays", `photo:..\..\beach.png=`+png, "notes:notes.txt=see photo")}
+	form, err := Receive(c, &Options{Progress: func(p Progress) { progress[p.File.Field] = p.File.Size }})
+	assert(err == nil && form.Values.Get("title") == "Holidays" && len(form.Files) == 2, "Upload should be received.")
+	photo := form.File("photo")
+	assert(photo.Filename == "beach.png" && photo.ContentType == "image/png" && photo.Size == int64(len(png)), "File should be described.")
+	assert(progress["photo"] == int64(len(png)) && progress["notes"] == 9, "Progress should be reported.")
+	rc, err := photo.Open()
+	assert(err == nil, "Stored file should be opened.")
+	content, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	assert(string(content) == png, "File should be stored.")
+
+	assert(photo.MoveTo(filepath.Join(dir, "beach.png")) == nil, "File should be moved.")
+	form.Cleanup()
+	_, err = os.Stat(filepath.Join(dir, "beach.png"))
+	assert(err == nil && stored(dir) == 1, "Moved file should be kept, others removed.")
+}
+
+func TestLimits(t *testing.T) {
+	_t = t
+	dir := setup()
+	defer os.RemoveAll(dir)
+
+	receive := func(opts *Options, parts ...string) error {
+		_, err := Receive(map[string]interface{}{"request": request(parts...)}, opts)
+		return err
+	}
+	err := receive(nil, "a:a.png="+png, "b:b.exe=MZ\x90\x00\x03\x00\x00\x00\x04\x00")
+	assert(err == ErrTypeNotAllowed && StatusCode(err) == http.StatusUnsupportedMediaType, "Sniffed type should be checked.")
+	err = receive(&Options{Types: []string{"image/png"}}, "a:a.png=just text")
+	assert(err == ErrTypeNotAllowed, "Type from file name should not be trusted.")
+	err = receive(&Options{MaxFileSize: 100}, "a:a.png="+png)
+	assert(err == ErrFileTooLarge && StatusCode(err) == http.StatusRequestEntityTooLarge, "File size should be limited.")
+	err = receive(&Options{MaxSize: 1500}, "a:a.png="+png, "b:b.png="+png)
+	assert(err == ErrTooLarge, "Request size should be limited.")
+	err = receive(nil, "a:a.png="+png, "b:b.png="+png, "c:c.png="+png)
+	assert(err == ErrTooManyFiles, "Number of files should be limited.")
+	assert(stored(dir) == 0, "Files of rejected uploads should be removed.")
+
+	_, err = Receive(map[string]interface{}{"request": httptest.NewRequest("POST", "/upload", strings.NewReader("a=1"))}, nil)
+	assert(err == ErrNotMultipart, "Only multipart requests should be received.")
+}